@@ -4,10 +4,26 @@ import (
 	"bytes"
 	"fmt"
 	"log"
+	"net/url"
 	"os"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/jessevdk/go-flags"
+	yaml "gopkg.in/yaml.v1"
+
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/relabel"
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/types"
+)
+
+// DefaultProxyRoute is the name of the route of targets matching no proxy
+// route
+const DefaultProxyRoute = "default"
+
+var (
+	proxyRouteNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+	labelNameRegexp      = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
 )
 
 // Config describes global configuration
@@ -23,6 +39,8 @@ type GeneralConfig struct {
 	Version bool          `short:"V" long:"version" description:"Display version."`
 	Manpage bool          `short:"m" long:"manpage" description:"Output manpage."`
 	Sleep   time.Duration `short:"s" long:"sleep" description:"Sleep time between queries." env:"SLEEP" default:"5s"`
+
+	MetricsListenAddress string `long:"metrics-listen-address" description:"Address to expose metrics on (disabled if empty)." env:"METRICS_LISTEN_ADDRESS"`
 }
 
 // PuppetDBConfig describes PuppetDB client configuration
@@ -37,16 +55,147 @@ type PuppetDBConfig struct {
 
 // PrometheusSDConfig describes Prometheus service discovery configuration
 type PrometheusSDConfig struct {
-	ProxyURL string `long:"proxy-url" description:"Prometheus target scraping proxy URL." env:"PROMETHEUS_PROXY_URL"`
+	ProxyURL   string `long:"proxy-url" description:"Prometheus target scraping proxy URL." env:"PROMETHEUS_PROXY_URL" yaml:"-"`
+	ConfigFile string `long:"config-file" description:"Prometheus service discovery configuration file." env:"PROMETHEUS_CONFIG_FILE" yaml:"-"`
+
+	InvalidLabelNamePolicy  LabelPolicy `long:"invalid-label-name-policy" description:"Policy for label names which are not valid Prometheus label names." choice:"reject" choice:"rewrite" choice:"drop" env:"PROMETHEUS_INVALID_LABEL_NAME_POLICY" default:"rewrite" yaml:"-"`
+	InvalidLabelValuePolicy LabelPolicy `long:"invalid-label-value-policy" description:"Policy for label values which are not valid UTF-8." choice:"reject" choice:"rewrite" choice:"drop" env:"PROMETHEUS_INVALID_LABEL_VALUE_POLICY" default:"rewrite" yaml:"-"`
+	ReservedLabelPolicy     LabelPolicy `long:"reserved-label-policy" description:"Policy for label names starting with '__'." choice:"allow" choice:"reject" choice:"rewrite" choice:"drop" env:"PROMETHEUS_RESERVED_LABEL_POLICY" default:"allow" yaml:"-"`
+	LabelCollisionPolicy    LabelPolicy `long:"label-collision-policy" description:"Policy for labels colliding with labels set by the service discovery." choice:"reject" choice:"rewrite" choice:"drop" env:"PROMETHEUS_LABEL_COLLISION_POLICY" default:"drop" yaml:"-"`
+
+	ExtraLabels           map[string]string     `long:"extra-labels" description:"Labels to add to every target." env:"PROMETHEUS_EXTRA_LABELS" env-delim:"," yaml:"-"`
+	ExtraLabelsPrecedence ExtraLabelsPrecedence `long:"extra-labels-precedence" description:"Labels kept when extra labels and resource labels have the same name." choice:"resource" choice:"extra" env:"PROMETHEUS_EXTRA_LABELS_PRECEDENCE" default:"resource" yaml:"-"`
+	CertnameLabel         string                `long:"certname-label" description:"Name of the label holding the certname of the node exporting a target." env:"PROMETHEUS_CERTNAME_LABEL" default:"certname" yaml:"-"`
+	DropCertnameLabel     bool                  `long:"drop-certname-label" description:"Do not add the certname label to targets." env:"PROMETHEUS_DROP_CERTNAME_LABEL" yaml:"-"`
+
+	IncludeJobs      []string `long:"include-job" description:"Keep only the jobs matching this regular expression (can be repeated)." env:"PROMETHEUS_INCLUDE_JOBS" env-delim:" " yaml:"-"`
+	ExcludeJobs      []string `long:"exclude-job" description:"Drop the jobs matching this regular expression (can be repeated)." env:"PROMETHEUS_EXCLUDE_JOBS" env-delim:" " yaml:"-"`
+	IncludeCertnames []string `long:"include-certname" description:"Keep only the certnames matching this regular expression (can be repeated)." env:"PROMETHEUS_INCLUDE_CERTNAMES" env-delim:" " yaml:"-"`
+	ExcludeCertnames []string `long:"exclude-certname" description:"Drop the certnames matching this regular expression (can be repeated)." env:"PROMETHEUS_EXCLUDE_CERTNAMES" env-delim:" " yaml:"-"`
+	IncludeLabels    []string `long:"include-label" description:"Keep only the targets with a label value matching a <name>=<regular expression> filter (can be repeated)." env:"PROMETHEUS_INCLUDE_LABELS" env-delim:" " yaml:"-"`
+	ExcludeLabels    []string `long:"exclude-label" description:"Drop the targets with a label value matching a <name>=<regular expression> filter (can be repeated)." env:"PROMETHEUS_EXCLUDE_LABELS" env-delim:" " yaml:"-"`
+
+	Shards int `long:"shards" description:"Number of shards to split targets into (sharding is disabled if lower than 2)." env:"PROMETHEUS_SHARDS" default:"1" yaml:"-"`
+
+	DuplicateTargetStrategy DuplicateTargetStrategy `long:"duplicate-target-strategy" description:"Strategy to resolve targets exported several times for the same job." choice:"merge" choice:"first" choice:"newest" env:"PROMETHEUS_DUPLICATE_TARGET_STRATEGY" default:"merge" yaml:"-"`
+
+	JobOverrides   []*JobOverride         `no-flag:"true" yaml:"job_overrides"`
+	RelabelConfigs []*types.RelabelConfig `no-flag:"true" yaml:"relabel_configs"`
+	ProxyRoutes    []*ProxyRoute          `no-flag:"true" yaml:"proxy_routes"`
+	Probes         []*Probe               `no-flag:"true" yaml:"probes"`
+}
+
+// JobOverride describes scrape_config settings enforced on the jobs matching
+// a job name or a regular expression, and the port added to their targets
+// without port
+type JobOverride struct {
+	JobName     string            `yaml:"job_name"`
+	JobRegex    string            `yaml:"job_regex"`
+	Default     types.JobSettings `yaml:"default"`
+	Override    types.JobSettings `yaml:"override"`
+	DefaultPort int               `yaml:"default_port"`
+
+	jobRegexp *regexp.Regexp
+}
+
+// Matches returns whether the override applies to a job
+func (o *JobOverride) Matches(jobName string) bool {
+	if o.jobRegexp != nil {
+		return o.jobRegexp.MatchString(jobName)
+	}
+	return o.JobName == jobName
+}
+
+// ProxyRoute describes the proxy used to scrape the targets matching a job
+// name or a regular expression, a certname regular expression and label
+// regular expressions. An empty proxy URL means targets are scraped without
+// proxy.
+type ProxyRoute struct {
+	Name          string            `yaml:"name"`
+	JobName       string            `yaml:"job_name"`
+	JobRegex      string            `yaml:"job_regex"`
+	CertnameRegex string            `yaml:"certname_regex"`
+	Labels        map[string]string `yaml:"labels"`
+	ProxyURL      string            `yaml:"proxy_url"`
+
+	jobRegexp      *regexp.Regexp
+	certnameRegexp *regexp.Regexp
+	labelRegexps   map[string]*regexp.Regexp
 }
 
+// Matches returns whether the route applies to targets of a job, exported by
+// a certname, with labels
+func (r *ProxyRoute) Matches(jobName, certname string, labels map[string]string) bool {
+	if r.jobRegexp != nil && !r.jobRegexp.MatchString(jobName) {
+		return false
+	}
+	if r.JobName != "" && r.JobName != jobName {
+		return false
+	}
+	if r.certnameRegexp != nil && !r.certnameRegexp.MatchString(certname) {
+		return false
+	}
+	for name, re := range r.labelRegexps {
+		if !re.MatchString(labels[name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Probe describes a blackbox exporter probing the targets of the jobs
+// matching a job name or a regular expression, or of the resources setting a
+// probe_module parameter when it matches no job
+type Probe struct {
+	JobName     string `yaml:"job_name"`
+	JobRegex    string `yaml:"job_regex"`
+	Prober      string `yaml:"prober"`
+	Module      string `yaml:"module"`
+	MetricsPath string `yaml:"metrics_path"`
+
+	jobRegexp *regexp.Regexp
+}
+
+// Matches returns whether the probe applies to a resource of a job, with a
+// probe module parameter
+func (p *Probe) Matches(jobName, probeModule string) bool {
+	if p.jobRegexp != nil {
+		return p.jobRegexp.MatchString(jobName)
+	}
+	if p.JobName != "" {
+		return p.JobName == jobName
+	}
+	return probeModule != ""
+}
+
+// LabelPolicy represents how labels failing validation are handled
+type LabelPolicy string
+
+// DuplicateTargetStrategy represents how targets exported several times for
+// the same job are resolved
+type DuplicateTargetStrategy string
+
+// ExtraLabelsPrecedence represents which of the extra labels and resource
+// labels are kept when they have the same name
+type ExtraLabelsPrecedence string
+
+// MissingConfigPolicy represents how a missing configuration object is
+// handled
+type MissingConfigPolicy string
+
 // OutputConfig describes output configuration
 type OutputConfig struct {
-	Method    OutputMethod          `short:"o" long:"method" description:"Output method." choice:"stdout" choice:"file" choice:"k8s-secret" env:"OUTPUT_METHOD" default:"stdout"`
-	Format    OutputFormat          `long:"format" description:"Output format." choice:"scrape-configs" choice:"static-configs" choice:"merged-static-configs" env:"OUTPUT_FORMAT" default:"scrape-configs"`
-	Stdout    StdoutOutputConfig    `group:"Stdout Output Configuration" namespace:"stdout"`
-	File      FileOutputConfig      `group:"File Output Configuration" namespace:"file"`
-	K8sSecret K8sSecretOutputConfig `group:"Kubernetes Secret Output Configuration" namespace:"k8s-secret"`
+	Method          OutputMethod                `short:"o" long:"method" description:"Output method." choice:"stdout" choice:"file" choice:"k8s-secret" choice:"k8s-configmap" choice:"k8s-scrape-config" choice:"k8s-service" env:"OUTPUT_METHOD" default:"stdout"`
+	Format          OutputFormat                `long:"format" description:"Output format." choice:"scrape-configs" choice:"static-configs" choice:"merged-static-configs" choice:"prometheus-config" env:"OUTPUT_FORMAT" default:"scrape-configs"`
+	Encoding        OutputEncoding              `long:"encoding" description:"Output encoding." choice:"yaml" choice:"json" env:"OUTPUT_ENCODING" default:"yaml"`
+	BaseConfigFile  string                      `long:"base-config-file" description:"Base Prometheus configuration file, into which the prometheus-config format merges scrape configurations." env:"OUTPUT_BASE_CONFIG_FILE"`
+	ExtraConfigFile string                      `long:"extra-config-file" description:"File containing additional scrape configs, or static configs with the merged-static-configs format." env:"OUTPUT_EXTRA_CONFIG_FILE"`
+	Stdout          StdoutOutputConfig          `group:"Stdout Output Configuration" namespace:"stdout"`
+	File            FileOutputConfig            `group:"File Output Configuration" namespace:"file"`
+	K8sSecret       K8sSecretOutputConfig       `group:"Kubernetes Secret Output Configuration" namespace:"k8s-secret"`
+	K8sConfigMap    K8sConfigMapOutputConfig    `group:"Kubernetes ConfigMap Output Configuration" namespace:"k8s-configmap"`
+	K8sScrapeConfig K8sScrapeConfigOutputConfig `group:"Kubernetes ScrapeConfig Output Configuration" namespace:"k8s-scrape-config"`
+	K8sService      K8sServiceOutputConfig      `group:"Kubernetes Service Output Configuration" namespace:"k8s-service"`
 }
 
 // OutputMethod represents an output method
@@ -55,34 +204,109 @@ type OutputMethod string
 // OutputFormat represents an output format
 type OutputFormat string
 
+// OutputEncoding represents an output encoding
+type OutputEncoding string
+
 // StdoutOutputConfig describes stdout output configuration
 type StdoutOutputConfig struct{}
 
 // FileOutputConfig describes file output configuration
 type FileOutputConfig struct {
 	Filename        string `short:"f" long:"filename" description:"Output filename." env:"OUTPUT_FILENAME" default:"puppetdb-sd.yml"`
-	FilenamePattern string `long:"filename-pattern" description:"Output filename pattern ('*' is the placeholder)." env:"OUTPUT_FILENAME_PATTERN" default:"*.yml"`
+	FilenamePattern string `long:"filename-pattern" description:"Output filename pattern ('*' or {{job}} and {{label \"<name>\"}} are placeholders)." env:"OUTPUT_FILENAME_PATTERN" default:"*.yml"`
 	Directory       string `long:"directory" description:"Output directory." env:"OUTPUT_DIRECTORY" default:"/etc/prometheus/puppetdb-sd"`
 }
 
 // K8sSecretOutputConfig describes Kubernetes secret output configuration
 type K8sSecretOutputConfig struct {
-	SecretName            string            `long:"secret-name" description:"Kubernetes secret name." env:"OUTPUT_K8S_SECRET_NAME"`
-	Namespace             string            `long:"namespace" description:"Kubernetes namespace." env:"OUTPUT_K8S_NAMESPACE"`
-	ObjectLabels          map[string]string `long:"object-labels" description:"Labels to add to Kubernetes objects." env:"OUTPUT_K8S_OBJECT_LABELS" default:"app.kubernetes.io/name:prometheus-puppetdb-sd"`
-	SecretKey             string            `long:"secret-key" description:"Kubernetes secret key." env:"OUTPUT_K8S_SECRET_KEY"`
-	SecretKeyPattern      string            `long:"secret-key-pattern" description:"Kubernetes secret key pattern ('*' is the placeholder)." env:"OUTPUT_K8S_SECRET_KEY_PATTERN"`
-	ExtraConfigSecretName string            `long:"extra-config-secret-name" description:"Kubernetes secret name containing additional config." env:"OUTPUT_K8S_EXTRA_CONFIG_SECRET_NAME"`
-	ExtraConfigSecretKey  string            `long:"extra-config-secret-key" description:"Key of the Kubernetes secret containing additional config." env:"OUTPUT_K8S_EXTRA_CONFIG_SECRET_KEY"`
+	SecretName            string              `long:"secret-name" description:"Kubernetes secret name." env:"OUTPUT_K8S_SECRET_NAME"`
+	Namespace             string              `long:"namespace" description:"Kubernetes namespace." env:"OUTPUT_K8S_NAMESPACE"`
+	ObjectLabels          map[string]string   `long:"object-labels" description:"Labels to add to Kubernetes objects." env:"OUTPUT_K8S_OBJECT_LABELS" default:"app.kubernetes.io/name:prometheus-puppetdb-sd"`
+	SecretKey             string              `long:"secret-key" description:"Kubernetes secret key." env:"OUTPUT_K8S_SECRET_KEY"`
+	SecretKeyPattern      string              `long:"secret-key-pattern" description:"Kubernetes secret key pattern ('*' or {{job}} and {{label \"<name>\"}} are placeholders)." env:"OUTPUT_K8S_SECRET_KEY_PATTERN"`
+	ExtraConfigSecretName string              `long:"extra-config-secret-name" description:"Kubernetes secret name containing additional config." env:"OUTPUT_K8S_EXTRA_CONFIG_SECRET_NAME"`
+	ExtraConfigSecretKey  string              `long:"extra-config-secret-key" description:"Key of the Kubernetes secret containing additional config." env:"OUTPUT_K8S_EXTRA_CONFIG_SECRET_KEY"`
+	MissingExtraConfig    MissingConfigPolicy `long:"missing-extra-config-policy" description:"Policy when the extra config secret or its key is missing." choice:"fail" choice:"ignore" env:"OUTPUT_K8S_MISSING_EXTRA_CONFIG_POLICY" default:"fail"`
+	BaseConfigSecretName  string              `long:"base-config-secret-name" description:"Kubernetes secret name containing the base Prometheus configuration, instead of --output.base-config-file." env:"OUTPUT_K8S_BASE_CONFIG_SECRET_NAME"`
+	BaseConfigSecretKey   string              `long:"base-config-secret-key" description:"Key of the Kubernetes secret containing the base Prometheus configuration." env:"OUTPUT_K8S_BASE_CONFIG_SECRET_KEY" default:"prometheus.yml"`
+	SplitSecrets          bool                `long:"split-secrets" description:"Split the output across secrets named after the secret name and an index, each holding at most the maximum secret size." env:"OUTPUT_K8S_SPLIT_SECRETS"`
+	MaxSecretSize         int                 `long:"max-secret-size" description:"Maximum size of the data of a secret, in bytes." env:"OUTPUT_K8S_MAX_SECRET_SIZE" default:"1048576"`
+}
+
+// K8sConfigMapOutputConfig describes Kubernetes ConfigMap output
+// configuration
+type K8sConfigMapOutputConfig struct {
+	ConfigMapName            string            `long:"configmap-name" description:"Kubernetes ConfigMap name." env:"OUTPUT_K8S_CONFIGMAP_NAME"`
+	Namespace                string            `long:"namespace" description:"Kubernetes namespace." env:"OUTPUT_K8S_CONFIGMAP_NAMESPACE"`
+	ObjectLabels             map[string]string `long:"object-labels" description:"Labels to add to Kubernetes objects." env:"OUTPUT_K8S_CONFIGMAP_OBJECT_LABELS" default:"app.kubernetes.io/name:prometheus-puppetdb-sd"`
+	ConfigMapKey             string            `long:"configmap-key" description:"Kubernetes ConfigMap key." env:"OUTPUT_K8S_CONFIGMAP_KEY"`
+	ConfigMapKeyPattern      string            `long:"configmap-key-pattern" description:"Kubernetes ConfigMap key pattern ('*' or {{job}} and {{label \"<name>\"}} are placeholders)." env:"OUTPUT_K8S_CONFIGMAP_KEY_PATTERN"`
+	ExtraConfigConfigMapName string            `long:"extra-config-configmap-name" description:"Kubernetes ConfigMap name containing additional config." env:"OUTPUT_K8S_EXTRA_CONFIG_CONFIGMAP_NAME"`
+	ExtraConfigConfigMapKey  string            `long:"extra-config-configmap-key" description:"Key of the Kubernetes ConfigMap containing additional config." env:"OUTPUT_K8S_EXTRA_CONFIG_CONFIGMAP_KEY"`
+}
+
+// K8sScrapeConfigOutputConfig describes Prometheus Operator ScrapeConfig
+// output configuration
+type K8sScrapeConfigOutputConfig struct {
+	Namespace    string            `long:"namespace" description:"Kubernetes namespace." env:"OUTPUT_K8S_SCRAPE_CONFIG_NAMESPACE"`
+	ObjectLabels map[string]string `long:"object-labels" description:"Labels to add to Kubernetes objects, also used to select the objects to garbage-collect." env:"OUTPUT_K8S_SCRAPE_CONFIG_OBJECT_LABELS" default:"app.kubernetes.io/name:prometheus-puppetdb-sd"`
+	NamePrefix   string            `long:"name-prefix" description:"Prefix of the ScrapeConfig object names." env:"OUTPUT_K8S_SCRAPE_CONFIG_NAME_PREFIX" default:"puppetdb-sd-"`
+}
+
+// K8sServiceOutputConfig describes Kubernetes Service and EndpointSlice output
+// configuration
+type K8sServiceOutputConfig struct {
+	Namespace    string            `long:"namespace" description:"Kubernetes namespace." env:"OUTPUT_K8S_SERVICE_NAMESPACE"`
+	ObjectLabels map[string]string `long:"object-labels" description:"Labels to add to Kubernetes objects, also used to select the objects to garbage-collect." env:"OUTPUT_K8S_SERVICE_OBJECT_LABELS" default:"app.kubernetes.io/name:prometheus-puppetdb-sd"`
+	NamePrefix   string            `long:"name-prefix" description:"Prefix of the Service names." env:"OUTPUT_K8S_SERVICE_NAME_PREFIX" default:"puppetdb-sd-"`
+	PortName     string            `long:"port-name" description:"Name of the Service port, suffixed with the port number when a job uses several ports." env:"OUTPUT_K8S_SERVICE_PORT_NAME" default:"metrics"`
 }
 
 const (
+	// AllowLabel label policy keeps the label as is
+	AllowLabel LabelPolicy = "allow"
+	// RejectLabel label policy skips the whole resource
+	RejectLabel LabelPolicy = "reject"
+	// RewriteLabel label policy rewrites the label to a valid one
+	RewriteLabel LabelPolicy = "rewrite"
+	// DropLabel label policy removes the label
+	DropLabel LabelPolicy = "drop"
+
+	// MergeDuplicateTargets strategy keeps the first target, adding the labels
+	// it does not have from the other ones
+	MergeDuplicateTargets DuplicateTargetStrategy = "merge"
+	// FirstDuplicateTarget strategy keeps the first target
+	FirstDuplicateTarget DuplicateTargetStrategy = "first"
+	// NewestDuplicateTarget strategy keeps the target of the node with the
+	// newest catalog
+	NewestDuplicateTarget DuplicateTargetStrategy = "newest"
+
+	// ResourceLabelsFirst keeps resource labels over extra labels
+	ResourceLabelsFirst ExtraLabelsPrecedence = "resource"
+	// ExtraLabelsFirst keeps extra labels over resource labels
+	ExtraLabelsFirst ExtraLabelsPrecedence = "extra"
+
+	// FailMissingConfig policy fails the output until the configuration is
+	// created
+	FailMissingConfig MissingConfigPolicy = "fail"
+	// IgnoreMissingConfig policy writes the output without the configuration
+	// and logs a warning
+	IgnoreMissingConfig MissingConfigPolicy = "ignore"
+
 	// Stdout output method prints Prometheus configuration on stdout
 	Stdout OutputMethod = "stdout"
 	// File output method stores Prometheus configuration into files
 	File OutputMethod = "file"
 	// K8sSecret output method stores Prometheus configuration into Kubernetes secret
 	K8sSecret OutputMethod = "k8s-secret"
+	// K8sConfigMap output method stores Prometheus configuration into Kubernetes ConfigMap
+	K8sConfigMap OutputMethod = "k8s-configmap"
+	// K8sScrapeConfig output method stores Prometheus configuration into
+	// Prometheus Operator ScrapeConfig objects
+	K8sScrapeConfig OutputMethod = "k8s-scrape-config"
+	// K8sService output method stores targets into Kubernetes headless
+	// Services and EndpointSlices
+	K8sService OutputMethod = "k8s-service"
 
 	// ScrapeConfigs output format renders a list of Prometheus scrape configurations
 	ScrapeConfigs OutputFormat = "scrape-configs"
@@ -90,6 +314,14 @@ const (
 	StaticConfigs OutputFormat = "static-configs"
 	// MergedStaticConfigs output format renders a unique list of Prometheus scrape configurations for all jobs
 	MergedStaticConfigs OutputFormat = "merged-static-configs"
+	// PrometheusConfig output format renders a complete Prometheus configuration,
+	// merging scrape configurations into a base configuration
+	PrometheusConfig OutputFormat = "prometheus-config"
+
+	// YAMLEncoding output encoding renders YAML documents
+	YAMLEncoding OutputEncoding = "yaml"
+	// JSONEncoding output encoding renders JSON documents
+	JSONEncoding OutputEncoding = "json"
 )
 
 // LoadConfig parses arguments
@@ -115,5 +347,207 @@ func LoadConfig(version string) (c Config) {
 		fmt.Printf("%s", buf.String())
 		os.Exit(0)
 	}
+
+	if c.PrometheusSD.ConfigFile != "" {
+		err = c.PrometheusSD.loadFile()
+		if err != nil {
+			log.Fatalf("Failed to load Prometheus service discovery configuration: %s", err)
+		}
+	}
+
+	_, err = NewFilter(&c.PrometheusSD)
+	if err != nil {
+		log.Fatalf("Invalid filters: %s", err)
+	}
+
+	err = c.PrometheusSD.validateLabels()
+	if err != nil {
+		log.Fatalf("Invalid labels: %s", err)
+	}
+	return
+}
+
+// loadFile loads the Prometheus service discovery configuration file
+func (c *PrometheusSDConfig) loadFile() (err error) {
+	content, err := os.ReadFile(c.ConfigFile)
+	if err != nil {
+		return
+	}
+
+	err = yaml.Unmarshal(content, c)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s (%s)", c.ConfigFile, err)
+	}
+
+	for i, o := range c.JobOverrides {
+		if (o.JobName == "") == (o.JobRegex == "") {
+			return fmt.Errorf("job override #%d must have either a job_name or a job_regex", i)
+		}
+
+		if o.JobRegex != "" {
+			o.jobRegexp, err = compileAnchored(o.JobRegex)
+			if err != nil {
+				return fmt.Errorf("invalid job_regex of job override #%d (%s)", i, err)
+			}
+		}
+
+		err = o.Default.Validate()
+		if err != nil {
+			return fmt.Errorf("invalid default settings of job override #%d (%s)", i, err)
+		}
+
+		err = o.Override.Validate()
+		if err != nil {
+			return fmt.Errorf("invalid override settings of job override #%d (%s)", i, err)
+		}
+
+		_, err = relabel.New(o.Default.MetricRelabelConfigs)
+		if err != nil {
+			return fmt.Errorf("invalid default metric_relabel_configs of job override #%d (%s)", i, err)
+		}
+
+		_, err = relabel.New(o.Override.MetricRelabelConfigs)
+		if err != nil {
+			return fmt.Errorf("invalid override metric_relabel_configs of job override #%d (%s)", i, err)
+		}
+	}
+
+	names := map[string]bool{}
+
+	for i, r := range c.ProxyRoutes {
+		err = r.Compile()
+		if err != nil {
+			return fmt.Errorf("invalid proxy route #%d (%s)", i, err)
+		}
+
+		if names[r.Name] || r.Name == DefaultProxyRoute {
+			return fmt.Errorf("invalid proxy route #%d (name '%s' is already used)", i, r.Name)
+		}
+		names[r.Name] = true
+	}
+
+	for i, p := range c.Probes {
+		err = p.Compile()
+		if err != nil {
+			return fmt.Errorf("invalid probe #%d (%s)", i, err)
+		}
+	}
+
+	_, err = relabel.New(c.RelabelConfigs)
 	return
 }
+
+// Compile validates a probe, compiles its regular expression and sets its
+// default metrics path
+func (p *Probe) Compile() (err error) {
+	if p.JobName != "" && p.JobRegex != "" {
+		return fmt.Errorf("job_name and job_regex are mutually exclusive")
+	}
+
+	if p.Prober == "" {
+		return fmt.Errorf("prober is required")
+	}
+
+	if p.Module == "" {
+		return fmt.Errorf("module is required")
+	}
+
+	if p.MetricsPath == "" {
+		p.MetricsPath = "/probe"
+	}
+
+	if p.JobRegex != "" {
+		p.jobRegexp, err = compileAnchored(p.JobRegex)
+		if err != nil {
+			return fmt.Errorf("invalid job_regex (%s)", err)
+		}
+	}
+	return
+}
+
+// Compile validates a proxy route and compiles its regular expressions
+func (r *ProxyRoute) Compile() (err error) {
+	if r.Name == "" || !proxyRouteNameRegexp.MatchString(r.Name) {
+		return fmt.Errorf("name '%s' must be made of letters, digits, '_' and '-'", r.Name)
+	}
+
+	if r.JobName != "" && r.JobRegex != "" {
+		return fmt.Errorf("job_name and job_regex are mutually exclusive")
+	}
+
+	if r.ProxyURL != "" {
+		var u *url.URL
+		u, err = url.Parse(r.ProxyURL)
+		if err != nil {
+			return fmt.Errorf("invalid proxy_url (%s)", err)
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid proxy_url '%s'", r.ProxyURL)
+		}
+	}
+
+	if r.JobRegex != "" {
+		r.jobRegexp, err = compileAnchored(r.JobRegex)
+		if err != nil {
+			return fmt.Errorf("invalid job_regex (%s)", err)
+		}
+	}
+
+	if r.CertnameRegex != "" {
+		r.certnameRegexp, err = compileAnchored(r.CertnameRegex)
+		if err != nil {
+			return fmt.Errorf("invalid certname_regex (%s)", err)
+		}
+	}
+
+	r.labelRegexps = make(map[string]*regexp.Regexp, len(r.Labels))
+	for name, regex := range r.Labels {
+		r.labelRegexps[name], err = compileAnchored(regex)
+		if err != nil {
+			return fmt.Errorf("invalid regex of label '%s' (%s)", name, err)
+		}
+	}
+	return
+}
+
+// validateLabels checks the names of the extra labels and of the certname
+// label. Since proxy routes read the certname from its label, they cannot
+// match certnames when it is dropped.
+func (c *PrometheusSDConfig) validateLabels() error {
+	if !c.DropCertnameLabel && !labelNameRegexp.MatchString(c.CertnameLabel) {
+		return fmt.Errorf("invalid certname label name '%s'", c.CertnameLabel)
+	}
+
+	for i, r := range c.ProxyRoutes {
+		if c.DropCertnameLabel && r.CertnameRegex != "" {
+			return fmt.Errorf("certname_regex of proxy route #%d requires the certname label, which is dropped", i)
+		}
+	}
+
+	for name := range c.ExtraLabels {
+		if !labelNameRegexp.MatchString(name) || strings.HasPrefix(name, "__") {
+			return fmt.Errorf("invalid extra label name '%s'", name)
+		}
+		if name == c.CertnameLabelName() {
+			return fmt.Errorf("extra label '%s' collides with the certname label", name)
+		}
+	}
+	return nil
+}
+
+// CertnameLabelName returns the name of the label holding the certname of the
+// node exporting a target, or an empty string if it is dropped
+func (c *PrometheusSDConfig) CertnameLabelName() string {
+	if c.DropCertnameLabel {
+		return ""
+	}
+	if c.CertnameLabel == "" {
+		return "certname"
+	}
+	return c.CertnameLabel
+}
+
+// compileAnchored compiles a regular expression matching whole strings
+func compileAnchored(regex string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + regex + ")$")
+}
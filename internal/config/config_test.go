@@ -0,0 +1,209 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testLoadFile(t *testing.T, content string) (c PrometheusSDConfig, err error) {
+	directory, err := os.MkdirTemp("", "prometheus-puppetdb-sd-test")
+	if err != nil {
+		assert.FailNow(t, "Failed to create temporary directory", err.Error())
+	}
+	defer os.RemoveAll(directory)
+
+	c.ConfigFile = filepath.Join(directory, "config.yml")
+
+	err = os.WriteFile(c.ConfigFile, []byte(content), 0644)
+	if err != nil {
+		assert.FailNow(t, "Failed to write configuration file", err.Error())
+	}
+
+	err = c.loadFile()
+	return
+}
+
+func TestLoadFileJobOverrides(t *testing.T) {
+	c, err := testLoadFile(t, `
+job_overrides:
+- job_regex: node-.*
+  default:
+    scrape_timeout: 10s
+  override:
+    sample_limit: 10000
+    metric_relabel_configs:
+    - source_labels: [__name__]
+      regex: node_systemd_unit_state
+      action: drop
+- job_name: apache-exporter
+  override:
+    scrape_interval: 1m
+`)
+	if err != nil {
+		assert.FailNow(t, "Failed to load configuration file", err.Error())
+	}
+
+	if assert.Len(t, c.JobOverrides, 2) {
+		assert.True(t, c.JobOverrides[0].Matches("node-exporter"))
+		assert.False(t, c.JobOverrides[0].Matches("my-node-exporter"))
+		assert.Equal(t, "10s", c.JobOverrides[0].Default.ScrapeTimeout)
+		assert.Equal(t, 10000, *c.JobOverrides[0].Override.SampleLimit)
+		assert.Equal(t, "drop", c.JobOverrides[0].Override.MetricRelabelConfigs[0].Action)
+
+		assert.True(t, c.JobOverrides[1].Matches("apache-exporter"))
+		assert.False(t, c.JobOverrides[1].Matches("node-exporter"))
+		assert.Equal(t, "1m", c.JobOverrides[1].Override.ScrapeInterval)
+	}
+}
+
+func TestLoadFileJobOverridesInvalid(t *testing.T) {
+	_, err := testLoadFile(t, `
+job_overrides:
+- job_name: node-exporter
+  job_regex: node-.*
+`)
+	assert.EqualError(t, err, "job override #0 must have either a job_name or a job_regex")
+
+	_, err = testLoadFile(t, `
+job_overrides:
+- job_regex: node-(
+`)
+	assert.ErrorContains(t, err, "invalid job_regex of job override #0")
+
+	_, err = testLoadFile(t, `
+job_overrides:
+- job_name: node-exporter
+  default:
+    scrape_interval: 10s
+    scrape_timeout: 30s
+`)
+	assert.EqualError(t, err, "invalid default settings of job override #0 (scrape_timeout '30s' is greater than scrape_interval '10s')")
+
+	_, err = testLoadFile(t, `
+job_overrides:
+- job_name: node-exporter
+  override:
+    scheme: ftp
+`)
+	assert.EqualError(t, err, "invalid override settings of job override #0 (scheme 'ftp' is not http or https)")
+
+	_, err = testLoadFile(t, `
+job_overrides:
+- job_name: node-exporter
+  override:
+    metric_relabel_configs:
+    - action: unknown
+`)
+	assert.EqualError(t, err, "invalid override metric_relabel_configs of job override #0 (relabel config #0: unknown action 'unknown')")
+}
+
+func TestLoadFileProxyRoutes(t *testing.T) {
+	c, err := testLoadFile(t, `
+proxy_routes:
+- name: dc1
+  certname_regex: .*\.dc1\.example\.com
+  proxy_url: http://proxy.dc1.example.com:3128
+- name: direct
+  job_regex: node-.*
+  labels:
+    network: dmz|lan
+`)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	if assert.Len(t, c.ProxyRoutes, 2) {
+		assert.True(t, c.ProxyRoutes[0].Matches("apache-exporter", "server-1.dc1.example.com", nil))
+		assert.False(t, c.ProxyRoutes[0].Matches("apache-exporter", "server-1.dc2.example.com", nil))
+
+		assert.True(t, c.ProxyRoutes[1].Matches("node-exporter", "", map[string]string{"network": "lan"}))
+		assert.False(t, c.ProxyRoutes[1].Matches("node-exporter", "", map[string]string{"network": "wan"}))
+		assert.False(t, c.ProxyRoutes[1].Matches("apache-exporter", "", map[string]string{"network": "lan"}))
+		assert.Equal(t, "", c.ProxyRoutes[1].ProxyURL)
+	}
+}
+
+func TestLoadFileProxyRoutesInvalid(t *testing.T) {
+	_, err := testLoadFile(t, `
+proxy_routes:
+- name: dc1
+  job_name: node-exporter
+  job_regex: node-.*
+`)
+	assert.EqualError(t, err, "invalid proxy route #0 (job_name and job_regex are mutually exclusive)")
+
+	_, err = testLoadFile(t, `
+proxy_routes:
+- name: dc1
+  proxy_url: proxy.example.com
+`)
+	assert.EqualError(t, err, "invalid proxy route #0 (invalid proxy_url 'proxy.example.com')")
+
+	_, err = testLoadFile(t, `
+proxy_routes:
+- name: dc1
+- name: dc1
+`)
+	assert.EqualError(t, err, "invalid proxy route #1 (name 'dc1' is already used)")
+
+	_, err = testLoadFile(t, `
+proxy_routes:
+- name: dc/1
+`)
+	assert.ErrorContains(t, err, "invalid proxy route #0 (name 'dc/1' must be made of")
+}
+
+func TestLoadFileProbes(t *testing.T) {
+	c, err := testLoadFile(t, `
+probes:
+- job_regex: website-.*
+  prober: blackbox.example.com:9115
+  module: http_2xx
+- prober: blackbox.example.com:9115
+  module: tcp_connect
+  metrics_path: /blackbox/probe
+`)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	if assert.Len(t, c.Probes, 2) {
+		assert.True(t, c.Probes[0].Matches("website-shop", ""))
+		assert.False(t, c.Probes[0].Matches("node-exporter", "icmp"))
+		assert.Equal(t, "/probe", c.Probes[0].MetricsPath)
+
+		assert.True(t, c.Probes[1].Matches("node-exporter", "icmp"))
+		assert.False(t, c.Probes[1].Matches("node-exporter", ""))
+		assert.Equal(t, "/blackbox/probe", c.Probes[1].MetricsPath)
+	}
+
+	_, err = testLoadFile(t, `
+probes:
+- job_name: website
+  module: http_2xx
+`)
+	assert.EqualError(t, err, "invalid probe #0 (prober is required)")
+}
+
+func TestValidateLabelsCertnameRoutes(t *testing.T) {
+	c, err := testLoadFile(t, `
+proxy_routes:
+- name: direct
+  labels:
+    network: lan
+- name: dc1
+  certname_regex: a.*
+`)
+	if err != nil {
+		assert.FailNow(t, "Failed to load configuration", err.Error())
+	}
+
+	c.CertnameLabel = "node"
+	assert.NoError(t, c.validateLabels())
+
+	c.DropCertnameLabel = true
+	assert.EqualError(t, c.validateLabels(), "certname_regex of proxy route #1 requires the certname label, which is dropped")
+}
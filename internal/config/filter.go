@@ -0,0 +1,126 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Filter reasons, reported along with the number of filtered targets
+const (
+	JobFilter      = "job"
+	CertnameFilter = "certname"
+	LabelFilter    = "label"
+)
+
+// Filter selects resources from their job name, certname and label values.
+// A resource is kept when it matches at least one include filter of each
+// kind, if any, and no exclude filter.
+type Filter struct {
+	includeJobs      []*regexp.Regexp
+	excludeJobs      []*regexp.Regexp
+	includeCertnames []*regexp.Regexp
+	excludeCertnames []*regexp.Regexp
+	includeLabels    []*labelMatcher
+	excludeLabels    []*labelMatcher
+}
+
+// labelMatcher matches the value of a label, missing labels having an empty
+// value
+type labelMatcher struct {
+	name   string
+	regexp *regexp.Regexp
+}
+
+// NewFilter compiles the include and exclude filters of a Prometheus service
+// discovery configuration
+func NewFilter(c *PrometheusSDConfig) (f *Filter, err error) {
+	f = &Filter{}
+
+	for _, p := range []struct {
+		option   string
+		patterns []string
+		regexps  *[]*regexp.Regexp
+	}{
+		{"include-job", c.IncludeJobs, &f.includeJobs},
+		{"exclude-job", c.ExcludeJobs, &f.excludeJobs},
+		{"include-certname", c.IncludeCertnames, &f.includeCertnames},
+		{"exclude-certname", c.ExcludeCertnames, &f.excludeCertnames},
+	} {
+		for _, pattern := range p.patterns {
+			var re *regexp.Regexp
+			re, err = compileAnchored(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s filter '%s' (%s)", p.option, pattern, err)
+			}
+			*p.regexps = append(*p.regexps, re)
+		}
+	}
+
+	for _, p := range []struct {
+		option   string
+		patterns []string
+		matchers *[]*labelMatcher
+	}{
+		{"include-label", c.IncludeLabels, &f.includeLabels},
+		{"exclude-label", c.ExcludeLabels, &f.excludeLabels},
+	} {
+		for _, pattern := range p.patterns {
+			name, regex, ok := strings.Cut(pattern, "=")
+			if !ok || name == "" {
+				return nil, fmt.Errorf("invalid %s filter '%s' (expected <name>=<regular expression>)", p.option, pattern)
+			}
+
+			var re *regexp.Regexp
+			re, err = compileAnchored(regex)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s filter '%s' (%s)", p.option, pattern, err)
+			}
+			*p.matchers = append(*p.matchers, &labelMatcher{name: name, regexp: re})
+		}
+	}
+	return
+}
+
+// Match returns the kind of filter discarding a resource of a job, with a
+// certname and labels, or an empty string if the resource is kept
+func (f *Filter) Match(jobName, certname string, labels map[string]string) string {
+	if !matchRegexps(f.includeJobs, jobName, true) || matchRegexps(f.excludeJobs, jobName, false) {
+		return JobFilter
+	}
+	if !matchRegexps(f.includeCertnames, certname, true) || matchRegexps(f.excludeCertnames, certname, false) {
+		return CertnameFilter
+	}
+	if !matchLabels(f.includeLabels, labels, true) || matchLabels(f.excludeLabels, labels, false) {
+		return LabelFilter
+	}
+	return ""
+}
+
+// matchRegexps returns whether any regular expression matches a value, or
+// empty if there are none
+func matchRegexps(regexps []*regexp.Regexp, value string, empty bool) bool {
+	if len(regexps) == 0 {
+		return empty
+	}
+	for _, re := range regexps {
+		if re.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchLabels returns whether any label matcher matches labels, or empty if
+// there are none
+func matchLabels(matchers []*labelMatcher, labels map[string]string, empty bool) bool {
+	if len(matchers) == 0 {
+		return empty
+	}
+	for _, m := range matchers {
+		if m.regexp.MatchString(labels[m.name]) {
+			return true
+		}
+	}
+	return false
+}
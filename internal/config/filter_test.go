@@ -0,0 +1,53 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterMatch(t *testing.T) {
+	f, err := NewFilter(&PrometheusSDConfig{
+		IncludeJobs:      []string{"node-.*", "apache-exporter"},
+		ExcludeCertnames: []string{`.*\.test\.example\.com`},
+		IncludeLabels:    []string{"team=team-1"},
+		ExcludeLabels:    []string{"environment=development"},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	for _, tc := range []struct {
+		jobName  string
+		certname string
+		labels   map[string]string
+		expected string
+	}{
+		{"node-exporter", "server-1.example.com", map[string]string{"team": "team-1"}, ""},
+		{"apache-exporter", "server-1.example.com", map[string]string{"team": "team-1", "environment": "production"}, ""},
+		{"my-node-exporter", "server-1.example.com", map[string]string{"team": "team-1"}, JobFilter},
+		{"node-exporter", "server-1.test.example.com", map[string]string{"team": "team-1"}, CertnameFilter},
+		{"node-exporter", "server-1.example.com", map[string]string{"team": "team-2"}, LabelFilter},
+		{"node-exporter", "server-1.example.com", map[string]string{}, LabelFilter},
+		{"node-exporter", "server-1.example.com", map[string]string{"team": "team-1", "environment": "development"}, LabelFilter},
+	} {
+		assert.Equal(t, tc.expected, f.Match(tc.jobName, tc.certname, tc.labels), "%s %s %v", tc.jobName, tc.certname, tc.labels)
+	}
+}
+
+func TestFilterMatchEmpty(t *testing.T) {
+	f, err := NewFilter(&PrometheusSDConfig{})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, "", f.Match("node-exporter", "server-1.example.com", nil))
+}
+
+func TestNewFilterErrors(t *testing.T) {
+	_, err := NewFilter(&PrometheusSDConfig{IncludeJobs: []string{"node-("}})
+	assert.ErrorContains(t, err, "invalid include-job filter 'node-('")
+
+	_, err = NewFilter(&PrometheusSDConfig{ExcludeLabels: []string{"team"}})
+	assert.EqualError(t, err, "invalid exclude-label filter 'team' (expected <name>=<regular expression>)")
+}
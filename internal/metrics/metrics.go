@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "prometheus_puppetdb_sd"
+
+var (
+	// DuplicateTargets reports the certnames of the resources exporting the
+	// same target for the same job
+	DuplicateTargets = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "duplicate_target",
+			Help:      "Targets exported several times for the same job, per exporting certname.",
+		},
+		[]string{"job", "target", "certname"},
+	)
+
+	// FilteredTargets reports the number of targets discarded by the include
+	// and exclude filters
+	FilteredTargets = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "filtered_targets",
+			Help:      "Number of targets discarded by the include and exclude filters, per kind of filter.",
+		},
+		[]string{"filter"},
+	)
+
+	// K8sSecretSizeRatio reports the size of the data of the output
+	// Kubernetes secrets, relative to their maximum size
+	K8sSecretSizeRatio = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "k8s_secret_size_ratio",
+			Help:      "Size of the data of the output Kubernetes secrets, as a ratio of the maximum secret size.",
+		},
+		[]string{"secret"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(
+		DuplicateTargets,
+		FilteredTargets,
+		K8sSecretSizeRatio,
+	)
+}
+
+// Serve exposes the metrics over HTTP
+func Serve(address string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	return http.ListenAndServe(address, mux)
+}
@@ -0,0 +1,60 @@
+package outputs
+
+import (
+	"fmt"
+
+	yaml "gopkg.in/yaml.v1"
+
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/types"
+)
+
+// mergeBaseConfig appends scrape configurations to the scrape_configs of a
+// base Prometheus configuration. Jobs already defined in the base
+// configuration are rejected, as Prometheus refuses duplicate job names.
+func mergeBaseConfig(base []byte, scrapeConfigs []*types.ScrapeConfig) (c []byte, err error) {
+	var document interface{}
+
+	err = yaml.Unmarshal(base, &document)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse base config (%s)", err)
+	}
+
+	prometheusConfig := map[interface{}]interface{}{}
+	if document != nil {
+		var ok bool
+		prometheusConfig, ok = document.(map[interface{}]interface{})
+		if !ok {
+			return nil, fmt.Errorf("base config is not a map")
+		}
+	}
+
+	var merged []interface{}
+	if existing := prometheusConfig["scrape_configs"]; existing != nil {
+		var ok bool
+		merged, ok = existing.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("scrape_configs of base config is not a list")
+		}
+	}
+
+	jobNames := map[string]struct{}{}
+	for _, item := range merged {
+		scrapeConfig, ok := item.(map[interface{}]interface{})
+		if !ok {
+			return nil, fmt.Errorf("scrape_configs of base config contains an item which is not a map")
+		}
+
+		jobName, _ := scrapeConfig["job_name"].(string)
+		jobNames[jobName] = struct{}{}
+	}
+
+	for _, scrapeConfig := range scrapeConfigs {
+		if _, ok := jobNames[scrapeConfig.JobName]; ok {
+			return nil, fmt.Errorf("job '%s' is already defined in base config", scrapeConfig.JobName)
+		}
+		merged = append(merged, scrapeConfig)
+	}
+	prometheusConfig["scrape_configs"] = merged
+
+	return yaml.Marshal(prometheusConfig)
+}
@@ -0,0 +1,114 @@
+package outputs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/config"
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/types"
+)
+
+const testBaseConfig = `
+global:
+  scrape_interval: 30s
+rule_files:
+- /etc/prometheus/rules/*.yml
+scrape_configs:
+- job_name: prometheus
+  static_configs:
+  - targets:
+    - localhost:9090
+`
+
+func TestMergeBaseConfig(t *testing.T) {
+	c, err := mergeBaseConfig([]byte(testBaseConfig), []*types.ScrapeConfig{
+		{
+			JobName: "node-exporter",
+			StaticConfigs: []*types.StaticConfig{
+				{Targets: []string{"server-1.example.com:9100"}},
+			},
+		},
+	})
+	if err != nil {
+		assert.FailNow(t, "Failed to merge base config", err.Error())
+	}
+
+	assert.Equal(t, `global:
+  scrape_interval: 30s
+rule_files:
+- /etc/prometheus/rules/*.yml
+scrape_configs:
+- job_name: prometheus
+  static_configs:
+  - targets:
+    - localhost:9090
+- job_name: node-exporter
+  static_configs:
+  - targets:
+    - server-1.example.com:9100
+    labels: {}
+`, string(c))
+}
+
+func TestMergeBaseConfigEmpty(t *testing.T) {
+	c, err := mergeBaseConfig(nil, []*types.ScrapeConfig{{JobName: "node-exporter"}})
+	if err != nil {
+		assert.FailNow(t, "Failed to merge base config", err.Error())
+	}
+
+	assert.Equal(t, "scrape_configs:\n- job_name: node-exporter\n  static_configs: []\n", string(c))
+}
+
+func TestMergeBaseConfigErrors(t *testing.T) {
+	_, err := mergeBaseConfig([]byte(testBaseConfig), []*types.ScrapeConfig{{JobName: "prometheus"}})
+	assert.EqualError(t, err, "job 'prometheus' is already defined in base config")
+
+	_, err = mergeBaseConfig([]byte("scrape_configs: prometheus\n"), nil)
+	assert.EqualError(t, err, "scrape_configs of base config is not a list")
+
+	_, err = mergeBaseConfig([]byte("scrape_configs:\n- prometheus\n"), nil)
+	assert.EqualError(t, err, "scrape_configs of base config contains an item which is not a map")
+
+	_, err = mergeBaseConfig([]byte("- prometheus\n"), nil)
+	assert.EqualError(t, err, "base config is not a map")
+
+	_, err = mergeBaseConfig([]byte("global: [\n"), nil)
+	assert.Error(t, err)
+}
+
+func TestRendererRenderPrometheusConfig(t *testing.T) {
+	baseConfigFile := filepath.Join(t.TempDir(), "prometheus-base.yml")
+	err := os.WriteFile(baseConfigFile, []byte(testBaseConfig), 0644)
+	if err != nil {
+		assert.FailNow(t, "Failed to write base config file", err.Error())
+	}
+
+	r := &renderer{
+		format:         config.PrometheusConfig,
+		encoding:       config.YAMLEncoding,
+		name:           "prometheus.yml",
+		baseConfigFile: baseConfigFile,
+	}
+
+	documents, err := r.render(scrapeConfigs[1])
+	if err != nil {
+		assert.FailNow(t, "Failed to render documents", err.Error())
+	}
+	assert.Contains(t, string(documents["prometheus.yml"]), "- job_name: prometheus\n")
+	assert.Contains(t, string(documents["prometheus.yml"]), "- job_name: node-exporter\n")
+
+	r.baseConfig = []byte("global:\n  scrape_interval: 1m\n")
+	documents, err = r.render(scrapeConfigs[1])
+	if err != nil {
+		assert.FailNow(t, "Failed to render documents", err.Error())
+	}
+	assert.NotContains(t, string(documents["prometheus.yml"]), "- job_name: prometheus\n")
+
+	r.baseConfig = nil
+	r.baseConfigFile = filepath.Join(t.TempDir(), "missing.yml")
+	_, err = r.render(scrapeConfigs[1])
+	assert.Error(t, err)
+}
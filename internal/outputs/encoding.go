@@ -0,0 +1,23 @@
+package outputs
+
+import (
+	"encoding/json"
+
+	yaml "gopkg.in/yaml.v1"
+
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/config"
+)
+
+// encode marshals a value with an output encoding. Label values are always
+// strings in JSON documents, so that no value can be read as another type.
+func encode(encoding config.OutputEncoding, v interface{}) (c []byte, err error) {
+	if encoding == config.JSONEncoding {
+		c, err = json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return
+		}
+		return append(c, '\n'), nil
+	}
+
+	return yaml.Marshal(v)
+}
@@ -0,0 +1,87 @@
+package outputs
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/config"
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/types"
+)
+
+func TestEncodeJSON(t *testing.T) {
+	staticConfigs := []*types.StaticConfig{
+		{
+			Targets: []string{"server-1.example.com:9100"},
+			Labels: map[string]string{
+				"enabled": "yes",
+				"rack":    "007",
+			},
+		},
+	}
+
+	c, err := encode(config.JSONEncoding, staticConfigs)
+	if err != nil {
+		assert.FailNow(t, "Failed to encode static configs", err.Error())
+	}
+
+	assert.Equal(t, strings.TrimSpace(`
+[
+  {
+    "targets": [
+      "server-1.example.com:9100"
+    ],
+    "labels": {
+      "enabled": "yes",
+      "rack": "007"
+    }
+  }
+]
+`), strings.TrimSpace(string(c)))
+}
+
+func TestEncodeMergedStaticConfigsJSON(t *testing.T) {
+	c, err := encode(config.JSONEncoding, appendExtraStaticConfigs(scrapeConfigs[0], nil))
+	if err != nil {
+		assert.FailNow(t, "Failed to encode static configs", err.Error())
+	}
+
+	var staticConfigs []*types.StaticConfig
+	err = json.Unmarshal(c, &staticConfigs)
+	if err != nil {
+		assert.FailNow(t, "Failed to decode static configs", err.Error())
+	}
+
+	assert.Equal(t, []*types.StaticConfig{
+		scrapeConfigs[0][0].StaticConfigs[0],
+		scrapeConfigs[0][0].StaticConfigs[1],
+		scrapeConfigs[0][1].StaticConfigs[0],
+	}, staticConfigs)
+}
+
+func TestEncodeScrapeConfigsJSON(t *testing.T) {
+	sampleLimit := 1000
+
+	c, err := encode(config.JSONEncoding, []*types.ScrapeConfig{
+		{
+			JobName: "node-exporter",
+			ScrapeSettings: types.ScrapeSettings{
+				SampleLimit: &sampleLimit,
+			},
+			StaticConfigs: []*types.StaticConfig{
+				{Targets: []string{"server-1.example.com:9100"}},
+			},
+		},
+	})
+	if err != nil {
+		assert.FailNow(t, "Failed to encode scrape configs", err.Error())
+	}
+
+	assert.JSONEq(t, `[{
+		"job_name": "node-exporter",
+		"sample_limit": 1000,
+		"static_configs": [{"targets": ["server-1.example.com:9100"]}]
+	}]`, string(c))
+}
@@ -0,0 +1,233 @@
+package outputs
+
+import (
+	"fmt"
+	"sort"
+
+	yaml "gopkg.in/yaml.v1"
+
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/config"
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/types"
+)
+
+// parseExtraConfig parses and validates extra configuration, which is a list
+// of scrape configurations, or of static configurations with the
+// merged-static-configs format. Maps are converted to string keys, and static
+// configurations to their type, so that items can be encoded in JSON as well.
+func parseExtraConfig(format config.OutputFormat, content []byte) (items []interface{}, err error) {
+	var document interface{}
+
+	err = yaml.Unmarshal(content, &document)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse extra config (%s)", err)
+	}
+	if document == nil {
+		return
+	}
+
+	// Decoding to strings keeps scalar targets and label values as written,
+	// such as 9100 or yes, rather than as numbers or booleans
+	var typed []struct {
+		types.StaticConfig `yaml:",inline"`
+		StaticConfigs      []*types.StaticConfig `yaml:"static_configs"`
+	}
+	err = yaml.Unmarshal(content, &typed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse extra config (%s)", err)
+	}
+
+	list, ok := stringKeys(document).([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("extra config is not a list")
+	}
+
+	for i, item := range list {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("item %d of extra config is not a map", i)
+		}
+
+		switch format {
+		case config.ScrapeConfigs, config.StaticConfigs:
+			jobName, ok := m["job_name"].(string)
+			if !ok || jobName == "" {
+				return nil, fmt.Errorf("item %d of extra config has no job_name", i)
+			}
+
+			if staticConfigs := m["static_configs"]; staticConfigs != nil {
+				list, ok := staticConfigs.([]interface{})
+				if !ok {
+					return nil, fmt.Errorf("static_configs of job '%s' of extra config is not a list", jobName)
+				}
+				for j, staticConfig := range list {
+					err = checkStaticConfig(staticConfig)
+					if err != nil {
+						return nil, fmt.Errorf("static config %d of job '%s' of extra config %s", j, jobName, err)
+					}
+				}
+				m["static_configs"] = typed[i].StaticConfigs
+			}
+
+			if format != config.StaticConfigs {
+				break
+			}
+			for key := range m {
+				if key != "job_name" && key != "static_configs" {
+					return nil, fmt.Errorf("job '%s' of extra config has key '%s', only job_name and static_configs are supported with the static-configs format", jobName, key)
+				}
+			}
+		case config.MergedStaticConfigs:
+			err = checkStaticConfig(m)
+			if err != nil {
+				return nil, fmt.Errorf("item %d of extra config %s", i, err)
+			}
+			list[i] = &typed[i].StaticConfig
+		default:
+			return nil, fmt.Errorf("extra config is not supported with the %s format", format)
+		}
+	}
+
+	return list, nil
+}
+
+// checkStaticConfig checks that a static configuration has a list of targets
+// and that its targets and label values are scalars
+func checkStaticConfig(v interface{}) (err error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("is not a map")
+	}
+
+	targets, ok := m["targets"].([]interface{})
+	if !ok {
+		return fmt.Errorf("has no targets list")
+	}
+	for _, target := range targets {
+		if target == nil || !isScalar(target) {
+			return fmt.Errorf("has a target which is not a scalar value")
+		}
+	}
+
+	if m["labels"] == nil {
+		return
+	}
+	labels, ok := m["labels"].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("has labels which are not a map")
+	}
+
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !isScalar(labels[name]) {
+			return fmt.Errorf("has label '%s' which is not a scalar value", name)
+		}
+	}
+	return
+}
+
+// isScalar returns whether a decoded YAML value is neither a list nor a map
+func isScalar(v interface{}) bool {
+	switch v.(type) {
+	case []interface{}, map[string]interface{}:
+		return false
+	default:
+		return true
+	}
+}
+
+// stringKeys recursively converts the maps of a decoded YAML value to maps
+// with string keys
+func stringKeys(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			m[fmt.Sprintf("%v", key)] = stringKeys(item)
+		}
+		return m
+	case []interface{}:
+		for i, item := range value {
+			value[i] = stringKeys(item)
+		}
+		return value
+	default:
+		return v
+	}
+}
+
+// appendExtraScrapeConfigs returns scrape configurations followed by extra
+// scrape configurations, rejecting extra jobs which are already defined
+func appendExtraScrapeConfigs(scrapeConfigs []*types.ScrapeConfig, extra []interface{}) (merged []interface{}, err error) {
+	merged = make([]interface{}, 0, len(scrapeConfigs)+len(extra))
+
+	jobNames := make(map[string]struct{}, len(scrapeConfigs))
+	for _, scrapeConfig := range scrapeConfigs {
+		jobNames[scrapeConfig.JobName] = struct{}{}
+		merged = append(merged, scrapeConfig)
+	}
+
+	for _, item := range extra {
+		jobName := item.(map[string]interface{})["job_name"].(string)
+		if _, ok := jobNames[jobName]; ok {
+			return nil, fmt.Errorf("job '%s' of extra config is already defined", jobName)
+		}
+		jobNames[jobName] = struct{}{}
+		merged = append(merged, item)
+	}
+	return
+}
+
+// appendExtraStaticConfigs returns the static configurations of all jobs
+// followed by extra static configurations
+func appendExtraStaticConfigs(scrapeConfigs []*types.ScrapeConfig, extra []interface{}) (merged []interface{}) {
+	merged = []interface{}{}
+	for _, scrapeConfig := range scrapeConfigs {
+		for _, staticConfig := range scrapeConfig.StaticConfigs {
+			merged = append(merged, staticConfig)
+		}
+	}
+	return append(merged, extra...)
+}
+
+// mergeExtraJobs adds the static configurations of extra jobs to the jobs of
+// the same name, or as new jobs. Scrape configurations are copied rather than
+// modified.
+func mergeExtraJobs(scrapeConfigs []*types.ScrapeConfig, extra []interface{}) (merged []*types.ScrapeConfig, err error) {
+	merged = make([]*types.ScrapeConfig, len(scrapeConfigs))
+	copy(merged, scrapeConfigs)
+
+	index := make(map[string]int, len(merged))
+	for i, scrapeConfig := range merged {
+		index[scrapeConfig.JobName] = i
+	}
+
+	for _, item := range extra {
+		var c []byte
+		c, err = yaml.Marshal(item)
+		if err != nil {
+			return
+		}
+
+		extraJob := &types.ScrapeConfig{}
+		err = yaml.Unmarshal(c, extraJob)
+		if err != nil {
+			return nil, fmt.Errorf("invalid static configs in extra config (%s)", err)
+		}
+
+		i, ok := index[extraJob.JobName]
+		if !ok {
+			index[extraJob.JobName] = len(merged)
+			merged = append(merged, extraJob)
+			continue
+		}
+
+		scrapeConfig := *merged[i]
+		scrapeConfig.StaticConfigs = append(append([]*types.StaticConfig{}, scrapeConfig.StaticConfigs...), extraJob.StaticConfigs...)
+		merged[i] = &scrapeConfig
+	}
+	return
+}
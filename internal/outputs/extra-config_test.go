@@ -0,0 +1,149 @@
+package outputs
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/config"
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/types"
+)
+
+func TestParseExtraConfigErrors(t *testing.T) {
+	for content, expectedError := range map[string]string{
+		"job_name: extra\n":      "extra config is not a list",
+		"- extra\n":              "item 0 of extra config is not a map",
+		"- static_configs: []\n": "item 0 of extra config has no job_name",
+		"- job_name: extra\n  scrape_interval: 1m\n":  "job 'extra' of extra config has key 'scrape_interval', only job_name and static_configs are supported with the static-configs format",
+		"- job_name: extra\n    static_configs: []\n": "failed to parse extra config (YAML error: line 1: mapping values are not allowed in this context)",
+	} {
+		_, err := parseExtraConfig(config.StaticConfigs, []byte(content))
+		assert.EqualError(t, err, expectedError, content)
+	}
+
+	for content, expectedError := range map[string]string{
+		"- job_name: extra\n":                           "item 0 of extra config has no targets list",
+		"- targets: [[a]]\n":                            "item 0 of extra config has a target which is not a scalar value",
+		"- targets: [a]\n  labels: [team]\n":            "item 0 of extra config has labels which are not a map",
+		"- targets: [a]\n  labels: {team: {name: a}}\n": "item 0 of extra config has label 'team' which is not a scalar value",
+	} {
+		_, err := parseExtraConfig(config.MergedStaticConfigs, []byte(content))
+		assert.EqualError(t, err, expectedError, content)
+	}
+
+	_, err := parseExtraConfig(config.ScrapeConfigs, []byte("- job_name: extra\n  static_configs:\n  - targets: [a]\n    labels: {team: [a]}\n"))
+	assert.EqualError(t, err, "static config 0 of job 'extra' of extra config has label 'team' which is not a scalar value")
+
+	items, err := parseExtraConfig(config.ScrapeConfigs, []byte("---\n- job_name: extra\n  scrape_interval: 1m\n"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, []interface{}{
+			map[string]interface{}{"job_name": "extra", "scrape_interval": "1m"},
+		}, items)
+	}
+
+	// Scalars are kept as written
+	items, err = parseExtraConfig(config.ScrapeConfigs, []byte("- job_name: extra\n  static_configs:\n  - targets: [9100]\n    labels: {port: 9100, ha: yes}\n"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, []interface{}{
+			map[string]interface{}{
+				"job_name": "extra",
+				"static_configs": []*types.StaticConfig{
+					{
+						Targets: []string{"9100"},
+						Labels:  map[string]string{"port": "9100", "ha": "yes"},
+					},
+				},
+			},
+		}, items)
+	}
+}
+
+func TestRendererRenderExtraConfig(t *testing.T) {
+	dir := t.TempDir()
+
+	r := &renderer{
+		encoding: config.YAMLEncoding,
+		name:     "puppetdb-sd.yml",
+		pattern:  "{{job}}.yml",
+	}
+
+	// Scrape configs
+	r.format = config.ScrapeConfigs
+	r.extraConfig = []byte("- job_name: extra\n  kubernetes_sd_configs:\n  - role: node\n")
+	documents, err := r.render(scrapeConfigs[1])
+	if err != nil {
+		assert.FailNow(t, "Failed to render documents", err.Error())
+	}
+	assert.Equal(t, expectedOutputs[1][config.ScrapeConfigs].(string)[1:]+`- job_name: extra
+  kubernetes_sd_configs:
+  - role: node
+`, string(documents["puppetdb-sd.yml"]))
+
+	r.extraConfig = []byte("- job_name: node-exporter\n")
+	_, err = r.render(scrapeConfigs[1])
+	assert.EqualError(t, err, "job 'node-exporter' of extra config is already defined")
+
+	// Merged static configs, in JSON
+	r.format = config.MergedStaticConfigs
+	r.encoding = config.JSONEncoding
+	r.extraConfig = []byte("- targets:\n  - extra.example.com:9100\n  labels:\n    team: team-3\n")
+	documents, err = r.render(scrapeConfigs[1])
+	if err != nil {
+		assert.FailNow(t, "Failed to render documents", err.Error())
+	}
+
+	var staticConfigs []*types.StaticConfig
+	err = json.Unmarshal(documents["puppetdb-sd.yml"], &staticConfigs)
+	if assert.NoError(t, err) && assert.Len(t, staticConfigs, 3) {
+		assert.Equal(t, &types.StaticConfig{
+			Targets: []string{"extra.example.com:9100"},
+			Labels:  map[string]string{"team": "team-3"},
+		}, staticConfigs[2])
+	}
+
+	// Scalar targets and label values are rendered as strings
+	r.extraConfig = []byte("- targets: [9100]\n  labels: {port: 9100, ha: yes}\n")
+	documents, err = r.render(scrapeConfigs[1])
+	if err != nil {
+		assert.FailNow(t, "Failed to render documents", err.Error())
+	}
+	var scalarStaticConfigs []*types.StaticConfig
+	err = json.Unmarshal(documents["puppetdb-sd.yml"], &scalarStaticConfigs)
+	if assert.NoError(t, err) && assert.Len(t, scalarStaticConfigs, 3) {
+		assert.Equal(t, &types.StaticConfig{
+			Targets: []string{"9100"},
+			Labels:  map[string]string{"port": "9100", "ha": "yes"},
+		}, scalarStaticConfigs[2])
+	}
+
+	// Static configs, from a file
+	extraConfigFile := filepath.Join(dir, "extra.yml")
+	err = os.WriteFile(extraConfigFile, []byte(`
+- job_name: node-exporter
+  static_configs:
+  - targets:
+    - extra.example.com:9100
+- job_name: extra
+  static_configs:
+  - targets:
+    - extra.example.com:9999
+`), 0644)
+	if err != nil {
+		assert.FailNow(t, "Failed to write extra config file", err.Error())
+	}
+
+	r.format = config.StaticConfigs
+	r.encoding = config.YAMLEncoding
+	r.extraConfig = nil
+	r.extraConfigFile = extraConfigFile
+	documents, err = r.render(scrapeConfigs[1])
+	if err != nil {
+		assert.FailNow(t, "Failed to render documents", err.Error())
+	}
+	assert.Equal(t, []string{"extra.yml", "node-exporter.yml"}, sortedDocumentNames(documents))
+	assert.Contains(t, string(documents["node-exporter.yml"]), "- extra.example.com:9100\n")
+	assert.Len(t, scrapeConfigs[1][0].StaticConfigs, 2)
+}
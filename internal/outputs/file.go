@@ -4,9 +4,6 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"strings"
-
-	yaml "gopkg.in/yaml.v1"
 
 	"github.com/camptocamp/prometheus-puppetdb-sd/internal/config"
 	"github.com/camptocamp/prometheus-puppetdb-sd/internal/types"
@@ -18,7 +15,10 @@ type FileOutput struct {
 	filenamePattern string
 	directory       string
 
-	format config.OutputFormat
+	format          config.OutputFormat
+	encoding        config.OutputEncoding
+	baseConfigFile  string
+	extraConfigFile string
 
 	state struct {
 		oldPaths map[string]struct{}
@@ -26,81 +26,68 @@ type FileOutput struct {
 }
 
 func setupFileOutput(cfg *config.OutputConfig) (*FileOutput, error) {
-	err := os.MkdirAll(cfg.File.Directory, 0755)
-	return &FileOutput{
+	o := &FileOutput{
 		filename:        cfg.File.Filename,
 		filenamePattern: cfg.File.FilenamePattern,
 		directory:       cfg.File.Directory,
 
-		format: cfg.Format,
-	}, err
+		format:          cfg.Format,
+		encoding:        cfg.Encoding,
+		baseConfigFile:  cfg.BaseConfigFile,
+		extraConfigFile: cfg.ExtraConfigFile,
+	}
+
+	err := o.renderer().validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid filename pattern: %s", err)
+	}
+
+	err = os.MkdirAll(cfg.File.Directory, 0755)
+	return o, err
+}
+
+// renderer returns the renderer of the output files
+func (o *FileOutput) renderer() *renderer {
+	return &renderer{
+		format:          o.format,
+		encoding:        o.encoding,
+		name:            o.filename,
+		pattern:         o.filenamePattern,
+		baseConfigFile:  o.baseConfigFile,
+		extraConfigFile: o.extraConfigFile,
+	}
 }
 
 // WriteOutput writes Prometheus configuration to files
 func (o *FileOutput) WriteOutput(ctx context.Context, scrapeConfigs []*types.ScrapeConfig) (err error) {
-	var c []byte
-	var mc []byte
+	files, err := o.renderer().render(scrapeConfigs)
+	if err != nil {
+		return
+	}
+
+	paths := map[string]struct{}{}
+
+	for filename, content := range files {
+		path := fmt.Sprintf("%s/%s", o.directory, filename)
 
-	switch o.format {
-	case config.ScrapeConfigs:
-		c, err = yaml.Marshal(scrapeConfigs)
+		err = writeFile(path, content)
 		if err != nil {
 			return
 		}
 
-		path := fmt.Sprintf("%s/%s", o.directory, o.filename)
+		paths[path] = struct{}{}
+		delete(o.state.oldPaths, path)
+	}
 
-		err = writeFile(path, c)
+	for path := range o.state.oldPaths {
+		err = os.Remove(path)
 		if err != nil {
 			return
 		}
-	case config.StaticConfigs, config.MergedStaticConfigs:
-		paths := map[string]struct{}{}
-
-		for _, scrapeConfig := range scrapeConfigs {
-			c, err = yaml.Marshal(scrapeConfig.StaticConfigs)
-			if err != nil {
-				return
-			}
-
-			if o.format == config.MergedStaticConfigs {
-				mc = append(mc, c...)
-			} else {
-				path := fmt.Sprintf("%s/%s", o.directory, strings.Replace(o.filenamePattern, "*", scrapeConfig.JobName, 1))
-
-				err = writeFile(path, c)
-				if err != nil {
-					return
-				}
-
-				paths[path] = struct{}{}
-				delete(o.state.oldPaths, path)
-			}
-		}
-
-		if o.format == config.MergedStaticConfigs {
-			path := fmt.Sprintf("%s/%s", o.directory, o.filename)
-
-			err = writeFile(path, mc)
-			if err != nil {
-				return
-			}
-		} else {
-			for path := range o.state.oldPaths {
-				err = os.Remove(path)
-				if err != nil {
-					return
-				}
-			}
-		}
-
-		o.state.oldPaths = paths
-	default:
-		err = fmt.Errorf("unexpected output format '%s'", o.format)
-
-		return
 	}
 
+	o.state.oldPaths = paths
+
 	return
 }
 
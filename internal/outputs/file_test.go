@@ -127,3 +127,64 @@ func TestFileWriteOutputMergedStaticConfigsSuccess(t *testing.T) {
 
 	o.testFileWriteOutput(t)
 }
+
+func TestFileWriteOutputStaticConfigsLabelPattern(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	directory, err := os.MkdirTemp("", "prometheus-puppetdb-sd-test")
+	if err != nil {
+		assert.FailNow(t, "Failed to create temporary directory", err.Error())
+	}
+	defer os.RemoveAll(directory)
+
+	o := FileOutput{
+		filenamePattern: `{{job}}-{{label "team"}}.yml`,
+		directory:       directory,
+		format:          config.StaticConfigs,
+	}
+
+	expectedFilenames := [][]string{
+		{
+			"apache-exporter-team-2.yml",
+			"node-exporter-team-1.yml",
+		},
+		{
+			"node-exporter-team-1.yml",
+			"node-exporter-team-2.yml",
+		},
+	}
+
+	for i := range scrapeConfigs {
+		err = o.WriteOutput(ctx, scrapeConfigs[i])
+		if err != nil {
+			assert.FailNow(t, "Failed to write output", err.Error())
+		}
+
+		entries, err := os.ReadDir(directory)
+		if err != nil {
+			assert.FailNow(t, "Failed to read output directory", err.Error())
+		}
+
+		filenames := []string{}
+		for _, entry := range entries {
+			filenames = append(filenames, entry.Name())
+		}
+
+		assert.Equal(t, expectedFilenames[i], filenames)
+	}
+
+	buf, err := os.ReadFile(filepath.Join(directory, "node-exporter-team-2.yml"))
+	if err != nil {
+		assert.FailNow(t, "Failed to read output file content", err.Error())
+	}
+
+	assert.Equal(t, strings.TrimSpace(`
+- targets:
+  - server-2.example.com:9100
+  labels:
+    certname: server-2.example.com
+    environment: development
+    team: team-2
+`), strings.TrimSpace(string(buf)))
+}
@@ -0,0 +1,134 @@
+package outputs
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	applycorev1 "k8s.io/client-go/applyconfigurations/core/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/config"
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/types"
+)
+
+// K8sConfigMapOutput stores data needed to fill a Kubernetes ConfigMap
+type K8sConfigMapOutput struct {
+	k8sClient kubernetes.Interface
+
+	configMapName       string
+	namespace           string
+	objectLabels        map[string]string
+	configMapKey        string
+	configMapKeyPattern string
+	extraConfigMapName  string
+	extraConfigMapKey   string
+
+	format          config.OutputFormat
+	encoding        config.OutputEncoding
+	baseConfigFile  string
+	extraConfigFile string
+}
+
+func setupK8sConfigMapOutput(cfg *config.OutputConfig) (*K8sConfigMapOutput, error) {
+	o := &K8sConfigMapOutput{
+		configMapName:       cfg.K8sConfigMap.ConfigMapName,
+		namespace:           cfg.K8sConfigMap.Namespace,
+		objectLabels:        cfg.K8sConfigMap.ObjectLabels,
+		configMapKey:        cfg.K8sConfigMap.ConfigMapKey,
+		configMapKeyPattern: cfg.K8sConfigMap.ConfigMapKeyPattern,
+		extraConfigMapName:  cfg.K8sConfigMap.ExtraConfigConfigMapName,
+		extraConfigMapKey:   cfg.K8sConfigMap.ExtraConfigConfigMapKey,
+
+		format:          cfg.Format,
+		encoding:        cfg.Encoding,
+		baseConfigFile:  cfg.BaseConfigFile,
+		extraConfigFile: cfg.ExtraConfigFile,
+	}
+
+	err := o.renderer().validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid configmap key pattern: %s", err)
+	}
+
+	restConfig, namespace, err := k8sClientConfig(o.namespace)
+	if err != nil {
+		return nil, err
+	}
+	o.namespace = namespace
+
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	o.k8sClient = clientset
+
+	return o, nil
+}
+
+// WriteOutput writes Prometheus configuration to a Kubernetes ConfigMap with
+// server-side apply
+func (o *K8sConfigMapOutput) WriteOutput(ctx context.Context, scrapeConfigs []*types.ScrapeConfig) (err error) {
+	r := o.renderer()
+
+	// Extra ConfigMap
+	r.extraConfig, err = o.getExtraConfigContent(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve extra config content (%s)", err)
+	}
+
+	data, err := r.render(scrapeConfigs)
+	if err != nil {
+		return
+	}
+
+	configMapData := make(map[string]string, len(data))
+	for key, content := range data {
+		configMapData[key] = string(content)
+	}
+
+	configMap := applycorev1.ConfigMap(o.configMapName, o.namespace).
+		WithLabels(o.objectLabels).
+		WithData(configMapData)
+
+	_, err = o.k8sClient.CoreV1().ConfigMaps(o.namespace).Apply(ctx, configMap, metav1.ApplyOptions{
+		FieldManager: fieldManager,
+		Force:        true,
+	})
+	if err != nil {
+		return k8sError("apply", fmt.Sprintf("configmap '%s'", o.configMapName), err)
+	}
+
+	return
+}
+
+// renderer returns the renderer of the ConfigMap keys
+func (o *K8sConfigMapOutput) renderer() *renderer {
+	return &renderer{
+		format:          o.format,
+		encoding:        o.encoding,
+		name:            o.configMapKey,
+		pattern:         o.configMapKeyPattern,
+		baseConfigFile:  o.baseConfigFile,
+		extraConfigFile: o.extraConfigFile,
+	}
+}
+
+// getExtraConfigContent returns the content of the extra config ConfigMap
+func (o *K8sConfigMapOutput) getExtraConfigContent(ctx context.Context) (content []byte, err error) {
+	if o.extraConfigMapName == "" || o.extraConfigMapKey == "" {
+		return
+	}
+
+	extraConfigMap, err := o.k8sClient.CoreV1().ConfigMaps(o.namespace).Get(ctx, o.extraConfigMapName, metav1.GetOptions{})
+	if err != nil {
+		return nil, k8sError("retrieve", fmt.Sprintf("extra configmap '%s'", o.extraConfigMapName), err)
+	}
+
+	extraContent, ok := extraConfigMap.Data[o.extraConfigMapKey]
+	if !ok {
+		return nil, fmt.Errorf("extra configmap has no key '%s'", o.extraConfigMapKey)
+	}
+	content = []byte(extraContent)
+	return
+}
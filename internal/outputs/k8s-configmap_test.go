@@ -0,0 +1,137 @@
+package outputs
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/config"
+)
+
+func (o *K8sConfigMapOutput) testK8sConfigMapWriteOutput(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	o.k8sClient = newApplyClientset()
+
+	o.configMapName = "prometheus-puppetdb-sd-out"
+	o.namespace = "monitoring"
+	o.objectLabels = map[string]string{
+		"app.kubernetes.io/name": "prometheus-puppetdb-sd",
+	}
+	o.configMapKey = "puppetdb-sd.yml"
+	o.configMapKeyPattern = "*.yml"
+
+	for i := range scrapeConfigs {
+		err := o.WriteOutput(ctx, scrapeConfigs[i])
+		if err != nil {
+			assert.FailNow(t, "Failed to write output", err.Error())
+		}
+
+		configMap, err := o.k8sClient.CoreV1().ConfigMaps(o.namespace).Get(ctx, o.configMapName, metav1.GetOptions{})
+		if err != nil {
+			assert.FailNow(t, "Failed to retrieve configmap", err.Error())
+		}
+
+		assert.Equal(t, o.objectLabels, configMap.Labels)
+
+		switch o.format {
+		case config.ScrapeConfigs, config.MergedStaticConfigs:
+			expectedOutput := expectedOutputs[i][o.format].(string)
+
+			assert.Equal(t, []string{o.configMapKey}, configMapKeys(configMap))
+			assert.Equal(t, strings.TrimSpace(expectedOutput), strings.TrimSpace(configMap.Data[o.configMapKey]))
+		case config.StaticConfigs:
+			expectedKeys := []string{}
+
+			for _, scrapeConfig := range scrapeConfigs[i] {
+				jobName := scrapeConfig.JobName
+
+				key := strings.Replace(o.configMapKeyPattern, "*", jobName, 1)
+				expectedOutput := expectedOutputs[i][o.format].(map[string]string)[jobName]
+
+				assert.Equal(t, strings.TrimSpace(expectedOutput), strings.TrimSpace(configMap.Data[key]))
+
+				expectedKeys = append(expectedKeys, key)
+			}
+
+			assert.ElementsMatch(t, expectedKeys, configMapKeys(configMap))
+		}
+	}
+}
+
+func configMapKeys(configMap *v1.ConfigMap) []string {
+	keys := []string{}
+	for key := range configMap.Data {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func TestK8sConfigMapWriteOutputScrapeConfigsSuccess(t *testing.T) {
+	o := K8sConfigMapOutput{
+		format: config.ScrapeConfigs,
+	}
+
+	o.testK8sConfigMapWriteOutput(t)
+}
+
+func TestK8sConfigMapWriteOutputStaticConfigsSuccess(t *testing.T) {
+	o := K8sConfigMapOutput{
+		format: config.StaticConfigs,
+	}
+
+	o.testK8sConfigMapWriteOutput(t)
+}
+
+func TestK8sConfigMapWriteOutputMergedStaticConfigsSuccess(t *testing.T) {
+	o := K8sConfigMapOutput{
+		format: config.MergedStaticConfigs,
+	}
+
+	o.testK8sConfigMapWriteOutput(t)
+}
+
+func TestK8sConfigMapWriteOutputExtraConfig(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	o := K8sConfigMapOutput{
+		k8sClient: newApplyClientset(&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "prometheus-extra",
+				Namespace: "monitoring",
+			},
+			Data: map[string]string{
+				"extra.yml": "- job_name: extra\n",
+			},
+		}),
+		configMapName:      "prometheus-puppetdb-sd-out",
+		namespace:          "monitoring",
+		configMapKey:       "puppetdb-sd.yml",
+		extraConfigMapName: "prometheus-extra",
+		extraConfigMapKey:  "extra.yml",
+		format:             config.ScrapeConfigs,
+	}
+
+	err := o.WriteOutput(ctx, scrapeConfigs[1])
+	if err != nil {
+		assert.FailNow(t, "Failed to write output", err.Error())
+	}
+
+	configMap, err := o.k8sClient.CoreV1().ConfigMaps(o.namespace).Get(ctx, o.configMapName, metav1.GetOptions{})
+	if err != nil {
+		assert.FailNow(t, "Failed to retrieve configmap", err.Error())
+	}
+
+	expectedOutput := strings.TrimSpace(expectedOutputs[1][config.ScrapeConfigs].(string)) + "\n- job_name: extra\n"
+	assert.Equal(t, expectedOutput, configMap.Data[o.configMapKey])
+
+	o.extraConfigMapKey = "missing.yml"
+	err = o.WriteOutput(ctx, scrapeConfigs[1])
+	assert.ErrorContains(t, err, "extra configmap has no key 'missing.yml'")
+}
@@ -0,0 +1,231 @@
+package outputs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/config"
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/types"
+)
+
+var scrapeConfigResource = schema.GroupVersionResource{
+	Group:    "monitoring.coreos.com",
+	Version:  "v1alpha1",
+	Resource: "scrapeconfigs",
+}
+
+// K8sScrapeConfigOutput stores data needed to manage Prometheus Operator
+// ScrapeConfig objects
+type K8sScrapeConfigOutput struct {
+	dynamicClient dynamic.Interface
+
+	namespace    string
+	objectLabels map[string]string
+	namePrefix   string
+}
+
+// scrapeConfigSpec represents the spec of a Prometheus Operator ScrapeConfig
+// See https://prometheus-operator.dev/docs/operator/api/#monitoring.coreos.com/v1alpha1.ScrapeConfigSpec
+type scrapeConfigSpec struct {
+	JobName           *string               `json:"jobName,omitempty"`
+	StaticConfigs     []scrapeStaticConfig  `json:"staticConfigs,omitempty"`
+	HonorLabels       *bool                 `json:"honorLabels,omitempty"`
+	Params            map[string][]string   `json:"params,omitempty"`
+	ScrapeInterval    string                `json:"scrapeInterval,omitempty"`
+	ScrapeTimeout     string                `json:"scrapeTimeout,omitempty"`
+	MetricsPath       string                `json:"metricsPath,omitempty"`
+	Scheme            string                `json:"scheme,omitempty"`
+	SampleLimit       *int64                `json:"sampleLimit,omitempty"`
+	ProxyURL          string                `json:"proxyUrl,omitempty"`
+	TLSConfig         *scrapeTLSConfig      `json:"tlsConfig,omitempty"`
+	MetricRelabelings []scrapeRelabelConfig `json:"metricRelabelings,omitempty"`
+}
+
+type scrapeStaticConfig struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+type scrapeTLSConfig struct {
+	ServerName         string `json:"serverName,omitempty"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty"`
+}
+
+type scrapeRelabelConfig struct {
+	SourceLabels []string `json:"sourceLabels,omitempty"`
+	Separator    *string  `json:"separator,omitempty"`
+	Regex        string   `json:"regex,omitempty"`
+	Modulus      uint64   `json:"modulus,omitempty"`
+	TargetLabel  string   `json:"targetLabel,omitempty"`
+	Replacement  *string  `json:"replacement,omitempty"`
+	Action       string   `json:"action,omitempty"`
+}
+
+func setupK8sScrapeConfigOutput(cfg *config.OutputConfig) (*K8sScrapeConfigOutput, error) {
+	o := &K8sScrapeConfigOutput{
+		namespace:    cfg.K8sScrapeConfig.Namespace,
+		objectLabels: cfg.K8sScrapeConfig.ObjectLabels,
+		namePrefix:   cfg.K8sScrapeConfig.NamePrefix,
+	}
+
+	if len(o.objectLabels) == 0 {
+		return nil, fmt.Errorf("object labels are required to select the objects to garbage-collect")
+	}
+
+	restConfig, namespace, err := k8sClientConfig(o.namespace)
+	if err != nil {
+		return nil, err
+	}
+	o.namespace = namespace
+
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	o.dynamicClient = client
+
+	return o, nil
+}
+
+// WriteOutput writes Prometheus configuration to ScrapeConfig objects, one
+// per job, and deletes the objects of the jobs which disappeared
+func (o *K8sScrapeConfigOutput) WriteOutput(ctx context.Context, scrapeConfigs []*types.ScrapeConfig) (err error) {
+	client := o.dynamicClient.Resource(scrapeConfigResource).Namespace(o.namespace)
+
+	names := objectNames(o.namePrefix, scrapeConfigs, sanitizeObjectName, maxObjectNameLength)
+
+	current := make(map[string]struct{}, len(names))
+
+	for _, scrapeConfig := range scrapeConfigs {
+		if unsupported := unsupportedScrapeConfigSettings(scrapeConfig); len(unsupported) > 0 {
+			log.Warnf("job '%s' has %s, which ScrapeConfig objects do not support, skipping it", scrapeConfig.JobName, strings.Join(unsupported, ", "))
+			continue
+		}
+
+		var object *unstructured.Unstructured
+		object, err = o.scrapeConfigObject(names[scrapeConfig.JobName], scrapeConfig)
+		if err != nil {
+			return fmt.Errorf("failed to build ScrapeConfig of job '%s' (%s)", scrapeConfig.JobName, err)
+		}
+
+		_, err = client.Apply(ctx, object.GetName(), object, metav1.ApplyOptions{
+			FieldManager: fieldManager,
+			Force:        true,
+		})
+		if err != nil {
+			return k8sError("apply", fmt.Sprintf("ScrapeConfig '%s'", object.GetName()), err)
+		}
+		current[object.GetName()] = struct{}{}
+	}
+
+	// Garbage-collect the objects of the jobs which disappeared
+	list, err := client.List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(o.objectLabels).String(),
+	})
+	if err != nil {
+		return k8sError("list", "ScrapeConfigs", err)
+	}
+
+	for _, item := range list.Items {
+		if _, ok := current[item.GetName()]; ok {
+			continue
+		}
+		if item.GetAnnotations()[ownerAnnotation] != o.namePrefix {
+			continue
+		}
+
+		err = client.Delete(ctx, item.GetName(), metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return k8sError("delete", fmt.Sprintf("ScrapeConfig '%s'", item.GetName()), err)
+		}
+	}
+
+	return nil
+}
+
+// unsupportedScrapeConfigSettings returns the settings of a job which
+// ScrapeConfig objects cannot carry, since they reference secrets rather than
+// credential and certificate files
+func unsupportedScrapeConfigSettings(scrapeConfig *types.ScrapeConfig) (unsupported []string) {
+	if scrapeConfig.BasicAuth != nil {
+		unsupported = append(unsupported, "basic_auth")
+	}
+	if tlsConfig := scrapeConfig.TLSConfig; tlsConfig != nil {
+		if tlsConfig.CAFile != "" {
+			unsupported = append(unsupported, "tls_config ca_file")
+		}
+		if tlsConfig.CertFile != "" {
+			unsupported = append(unsupported, "tls_config cert_file")
+		}
+		if tlsConfig.KeyFile != "" {
+			unsupported = append(unsupported, "tls_config key_file")
+		}
+	}
+	return
+}
+
+// scrapeConfigObject builds the ScrapeConfig object of a job
+func (o *K8sScrapeConfigOutput) scrapeConfigObject(name string, scrapeConfig *types.ScrapeConfig) (object *unstructured.Unstructured, err error) {
+	jobName := scrapeConfig.JobName
+
+	spec := scrapeConfigSpec{
+		JobName:        &jobName,
+		HonorLabels:    scrapeConfig.HonorLabels,
+		Params:         scrapeConfig.Params,
+		ScrapeInterval: scrapeConfig.ScrapeInterval,
+		ScrapeTimeout:  scrapeConfig.ScrapeTimeout,
+		MetricsPath:    scrapeConfig.MetricsPath,
+		Scheme:         strings.ToUpper(scrapeConfig.Scheme),
+		ProxyURL:       scrapeConfig.ProxyURL,
+	}
+
+	if scrapeConfig.SampleLimit != nil {
+		sampleLimit := int64(*scrapeConfig.SampleLimit)
+		spec.SampleLimit = &sampleLimit
+	}
+
+	if tlsConfig := scrapeConfig.TLSConfig; tlsConfig != nil {
+		if tlsConfig.ServerName != "" || tlsConfig.InsecureSkipVerify {
+			spec.TLSConfig = &scrapeTLSConfig{
+				ServerName:         tlsConfig.ServerName,
+				InsecureSkipVerify: tlsConfig.InsecureSkipVerify,
+			}
+		}
+	}
+
+	for _, staticConfig := range scrapeConfig.StaticConfigs {
+		spec.StaticConfigs = append(spec.StaticConfigs, scrapeStaticConfig{
+			Targets: staticConfig.Targets,
+			Labels:  staticConfig.Labels,
+		})
+	}
+
+	for _, relabelConfig := range scrapeConfig.MetricRelabelConfigs {
+		spec.MetricRelabelings = append(spec.MetricRelabelings, scrapeRelabelConfig(*relabelConfig))
+	}
+
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&spec)
+	if err != nil {
+		return
+	}
+
+	object = &unstructured.Unstructured{}
+	object.SetAPIVersion(scrapeConfigResource.GroupVersion().String())
+	object.SetKind("ScrapeConfig")
+	object.SetName(name)
+	object.SetNamespace(o.namespace)
+	object.SetLabels(o.objectLabels)
+	object.SetAnnotations(map[string]string{ownerAnnotation: o.namePrefix})
+	object.Object["spec"] = content
+	return
+}
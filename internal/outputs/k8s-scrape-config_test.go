@@ -0,0 +1,247 @@
+package outputs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/types"
+)
+
+func testK8sScrapeConfigOutput(objects ...runtime.Object) *K8sScrapeConfigOutput {
+	return &K8sScrapeConfigOutput{
+		dynamicClient: newApplyDynamicClient(objects...),
+		namespace:     "monitoring",
+		objectLabels: map[string]string{
+			"app.kubernetes.io/name": "prometheus-puppetdb-sd",
+		},
+		namePrefix: "puppetdb-sd-",
+	}
+}
+
+func listScrapeConfigs(t *testing.T, client dynamic.Interface) map[string]*unstructured.Unstructured {
+	list, err := client.Resource(scrapeConfigResource).Namespace("monitoring").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		assert.FailNow(t, "Failed to list ScrapeConfigs", err.Error())
+	}
+
+	objects := map[string]*unstructured.Unstructured{}
+	for i := range list.Items {
+		objects[list.Items[i].GetName()] = &list.Items[i]
+	}
+	return objects
+}
+
+func TestK8sScrapeConfigWriteOutput(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	unmanaged := &unstructured.Unstructured{}
+	unmanaged.SetAPIVersion("monitoring.coreos.com/v1alpha1")
+	unmanaged.SetKind("ScrapeConfig")
+	unmanaged.SetNamespace("monitoring")
+	unmanaged.SetName("other")
+
+	o := testK8sScrapeConfigOutput(unmanaged)
+
+	expectedNames := [][]string{
+		{"other", "puppetdb-sd-apache-exporter", "puppetdb-sd-node-exporter"},
+		{"other", "puppetdb-sd-node-exporter"},
+	}
+
+	for i := range scrapeConfigs {
+		err := o.WriteOutput(ctx, scrapeConfigs[i])
+		if err != nil {
+			assert.FailNow(t, "Failed to write output", err.Error())
+		}
+
+		objects := listScrapeConfigs(t, o.dynamicClient)
+
+		names := []string{}
+		for name := range objects {
+			names = append(names, name)
+		}
+		assert.ElementsMatch(t, expectedNames[i], names)
+
+		object := objects["puppetdb-sd-node-exporter"]
+		assert.Equal(t, o.objectLabels, object.GetLabels())
+
+		jobName, _, _ := unstructured.NestedString(object.Object, "spec", "jobName")
+		assert.Equal(t, "node-exporter", jobName)
+
+		staticConfigs, _, _ := unstructured.NestedSlice(object.Object, "spec", "staticConfigs")
+		if assert.Len(t, staticConfigs, 2) {
+			labels, _, _ := unstructured.NestedStringMap(staticConfigs[1].(map[string]interface{}), "labels")
+			assert.Equal(t, scrapeConfigs[i][0].StaticConfigs[1].Labels, labels)
+		}
+	}
+}
+
+func TestK8sScrapeConfigWriteOutputKeepsOtherFields(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	existing := &unstructured.Unstructured{}
+	existing.SetAPIVersion("monitoring.coreos.com/v1alpha1")
+	existing.SetKind("ScrapeConfig")
+	existing.SetNamespace("monitoring")
+	existing.SetName("puppetdb-sd-node-exporter")
+	existing.SetLabels(map[string]string{"team": "team-1"})
+	existing.SetAnnotations(map[string]string{"owner": "team-1"})
+
+	o := testK8sScrapeConfigOutput(existing)
+
+	for i := range scrapeConfigs {
+		err := o.WriteOutput(ctx, scrapeConfigs[i])
+		if err != nil {
+			assert.FailNow(t, "Failed to write output", err.Error())
+		}
+	}
+
+	object := listScrapeConfigs(t, o.dynamicClient)["puppetdb-sd-node-exporter"]
+	assert.Equal(t, map[string]string{
+		"app.kubernetes.io/name": "prometheus-puppetdb-sd",
+		"team":                   "team-1",
+	}, object.GetLabels())
+	assert.Equal(t, map[string]string{
+		"owner":         "team-1",
+		ownerAnnotation: "puppetdb-sd-",
+	}, object.GetAnnotations())
+
+	jobName, _, _ := unstructured.NestedString(object.Object, "spec", "jobName")
+	assert.Equal(t, "node-exporter", jobName)
+}
+
+func TestK8sScrapeConfigWriteOutputKeepsForeignObjects(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	// Object of another instance with the same object labels
+	foreign := &unstructured.Unstructured{}
+	foreign.SetAPIVersion("monitoring.coreos.com/v1alpha1")
+	foreign.SetKind("ScrapeConfig")
+	foreign.SetNamespace("monitoring")
+	foreign.SetName("puppetdb-sd-dc2-node-exporter")
+	foreign.SetLabels(map[string]string{"app.kubernetes.io/name": "prometheus-puppetdb-sd"})
+	foreign.SetAnnotations(map[string]string{ownerAnnotation: "puppetdb-sd-dc2-"})
+
+	o := testK8sScrapeConfigOutput(foreign)
+
+	for i := range scrapeConfigs {
+		err := o.WriteOutput(ctx, scrapeConfigs[i])
+		if err != nil {
+			assert.FailNow(t, "Failed to write output", err.Error())
+		}
+	}
+
+	assert.Contains(t, listScrapeConfigs(t, o.dynamicClient), "puppetdb-sd-dc2-node-exporter")
+}
+
+func TestK8sScrapeConfigWriteOutputUnsupportedSettings(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	o := testK8sScrapeConfigOutput()
+
+	nodeExporter := &types.ScrapeConfig{
+		JobName: "node-exporter",
+		StaticConfigs: []*types.StaticConfig{
+			{Targets: []string{"server-1.example.com:9100"}},
+		},
+	}
+
+	err := o.WriteOutput(ctx, []*types.ScrapeConfig{nodeExporter})
+	if err != nil {
+		assert.FailNow(t, "Failed to write output", err.Error())
+	}
+	assert.Contains(t, listScrapeConfigs(t, o.dynamicClient), "puppetdb-sd-node-exporter")
+
+	// The job previously published is deleted rather than left without its
+	// credentials
+	nodeExporter.BasicAuth = &types.BasicAuth{Username: "prometheus", PasswordFile: "/etc/prometheus/password"}
+	err = o.WriteOutput(ctx, []*types.ScrapeConfig{nodeExporter})
+	if err != nil {
+		assert.FailNow(t, "Failed to write output", err.Error())
+	}
+	assert.NotContains(t, listScrapeConfigs(t, o.dynamicClient), "puppetdb-sd-node-exporter")
+
+	assert.Equal(t, []string{"basic_auth", "tls_config ca_file", "tls_config key_file"}, unsupportedScrapeConfigSettings(&types.ScrapeConfig{
+		JobName: "node-exporter",
+		ScrapeSettings: types.ScrapeSettings{
+			BasicAuth: &types.BasicAuth{Username: "prometheus"},
+			TLSConfig: &types.TLSConfig{CAFile: "/etc/prometheus/ca.pem", KeyFile: "/etc/prometheus/key.pem", ServerName: "a"},
+		},
+	}))
+	assert.Empty(t, unsupportedScrapeConfigSettings(&types.ScrapeConfig{
+		JobName: "node-exporter",
+		ScrapeSettings: types.ScrapeSettings{
+			TLSConfig: &types.TLSConfig{InsecureSkipVerify: true},
+		},
+	}))
+}
+
+func TestK8sScrapeConfigObject(t *testing.T) {
+	sampleLimit := 1000
+
+	o := testK8sScrapeConfigOutput()
+
+	object, err := o.scrapeConfigObject("puppetdb-sd-node-exporter", &types.ScrapeConfig{
+		JobName: "node-exporter",
+		ScrapeSettings: types.ScrapeSettings{
+			Scheme:      "https",
+			SampleLimit: &sampleLimit,
+			TLSConfig: &types.TLSConfig{
+				ServerName: "node.example.com",
+			},
+		},
+		ProxyURL: "http://proxy.example.com:3128",
+		MetricRelabelConfigs: []*types.RelabelConfig{
+			{SourceLabels: []string{"__name__"}, Regex: "node_systemd_.*", Action: "drop"},
+		},
+		StaticConfigs: []*types.StaticConfig{
+			{Targets: []string{"server-1.example.com:9100"}},
+		},
+	})
+	if err != nil {
+		assert.FailNow(t, "Failed to build ScrapeConfig", err.Error())
+	}
+
+	assert.Equal(t, map[string]interface{}{
+		"jobName":     "node-exporter",
+		"scheme":      "HTTPS",
+		"sampleLimit": int64(1000),
+		"proxyUrl":    "http://proxy.example.com:3128",
+		"tlsConfig": map[string]interface{}{
+			"serverName": "node.example.com",
+		},
+		"metricRelabelings": []interface{}{
+			map[string]interface{}{
+				"sourceLabels": []interface{}{"__name__"},
+				"regex":        "node_systemd_.*",
+				"action":       "drop",
+			},
+		},
+		"staticConfigs": []interface{}{
+			map[string]interface{}{
+				"targets": []interface{}{"server-1.example.com:9100"},
+			},
+		},
+	}, object.Object["spec"])
+}
+
+func TestK8sScrapeConfigObjectNames(t *testing.T) {
+	names := objectNames("puppetdb-sd-", []*types.ScrapeConfig{
+		{JobName: "node-exporter"},
+		{JobName: "Node_Exporter"},
+		{JobName: "apache-exporter"},
+	}, sanitizeObjectName, maxObjectNameLength)
+
+	assert.Equal(t, "puppetdb-sd-node-exporter", names["node-exporter"])
+	assert.Equal(t, "puppetdb-sd-node-exporter-"+shortHash("Node_Exporter"), names["Node_Exporter"])
+	assert.Equal(t, "puppetdb-sd-apache-exporter", names["apache-exporter"])
+}
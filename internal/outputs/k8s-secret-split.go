@@ -0,0 +1,146 @@
+package outputs
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	yaml "gopkg.in/yaml.v1"
+
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/config"
+)
+
+const (
+	// maxK8sSecretSize is the maximum size of the data of a Kubernetes Secret
+	maxK8sSecretSize = 1024 * 1024
+
+	// secretSizeWarningRatio is the ratio of the maximum secret size above
+	// which the size of a secret is reported in the logs
+	secretSizeWarningRatio = 0.9
+)
+
+// secretDataSize returns the size of the data of a secret, as counted by the
+// Kubernetes API server
+func secretDataSize(data map[string][]byte) (size int) {
+	for key, content := range data {
+		size += len(key) + len(content)
+	}
+	return
+}
+
+// splitSecretData distributes the keys of a secret across several secrets,
+// each holding at most maxSize bytes of data. Keys are assigned in order, and
+// documents too large for a secret are split into several keys named after
+// the document name with an index before its extension, such as
+// puppetdb-sd-0.yml and puppetdb-sd-1.yml for puppetdb-sd.yml.
+func splitSecretData(data map[string][]byte, encoding config.OutputEncoding, maxSize int) (secrets []map[string][]byte, err error) {
+	var keys []string
+	contents := map[string][]byte{}
+
+	for _, key := range sortedDocumentNames(data) {
+		content := data[key]
+
+		if len(key)+len(content) <= maxSize {
+			keys = append(keys, key)
+			contents[key] = content
+			continue
+		}
+
+		var chunks [][]byte
+		chunks, err = splitDocument(content, encoding, maxSize-len(key)-len("-0000"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to split key '%s' (%s)", key, err)
+		}
+
+		ext := filepath.Ext(key)
+		for i, chunk := range chunks {
+			chunkKey := fmt.Sprintf("%s-%d%s", strings.TrimSuffix(key, ext), i, ext)
+			keys = append(keys, chunkKey)
+			contents[chunkKey] = chunk
+		}
+	}
+
+	current := map[string][]byte{}
+	currentSize := 0
+
+	for _, key := range keys {
+		size := len(key) + len(contents[key])
+		if currentSize+size > maxSize && len(current) > 0 {
+			secrets = append(secrets, current)
+			current = map[string][]byte{}
+			currentSize = 0
+		}
+
+		current[key] = contents[key]
+		currentSize += size
+	}
+
+	return append(secrets, current), nil
+}
+
+// splitDocument splits a document holding a list into several documents of
+// at most maxSize bytes, keeping the order of the items
+func splitDocument(content []byte, encoding config.OutputEncoding, maxSize int) (chunks [][]byte, err error) {
+	var items []interface{}
+
+	if encoding == config.JSONEncoding {
+		var rawItems []json.RawMessage
+		err = json.Unmarshal(content, &rawItems)
+		for _, item := range rawItems {
+			items = append(items, item)
+		}
+	} else {
+		var document interface{}
+		err = yaml.Unmarshal(content, &document)
+
+		var ok bool
+		items, ok = document.([]interface{})
+		if err == nil && !ok {
+			err = fmt.Errorf("unexpected %T", document)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("document is not a list (%s)", err)
+	}
+
+	var chunk []interface{}
+	chunkSize := 0
+
+	flush := func() error {
+		c, err := encode(encoding, chunk)
+		if err != nil {
+			return err
+		}
+		chunks = append(chunks, c)
+		chunk = nil
+		chunkSize = 0
+		return nil
+	}
+
+	for _, item := range items {
+		var c []byte
+		c, err = encode(encoding, []interface{}{item})
+		if err != nil {
+			return
+		}
+		if len(c) > maxSize {
+			return nil, fmt.Errorf("an item of %d bytes is larger than the maximum size", len(c))
+		}
+
+		if chunkSize+len(c) > maxSize && len(chunk) > 0 {
+			err = flush()
+			if err != nil {
+				return
+			}
+		}
+
+		chunk = append(chunk, item)
+		chunkSize += len(c)
+	}
+
+	if len(chunk) > 0 {
+		err = flush()
+	}
+	return
+}
@@ -0,0 +1,139 @@
+package outputs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	yaml "gopkg.in/yaml.v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/config"
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/types"
+)
+
+func TestSplitSecretData(t *testing.T) {
+	for _, encoding := range []config.OutputEncoding{config.YAMLEncoding, config.JSONEncoding} {
+		r := &renderer{
+			format:   config.MergedStaticConfigs,
+			encoding: encoding,
+			name:     "puppetdb-sd.yml",
+		}
+
+		data, err := r.render(scrapeConfigs[0])
+		if err != nil {
+			assert.FailNow(t, "Failed to render documents", err.Error())
+		}
+		data["other.yml"] = []byte("[]\n")
+
+		secrets, err := splitSecretData(data, encoding, 250)
+		if err != nil {
+			assert.FailNow(t, "Failed to split secret data", err.Error())
+		}
+
+		keys := []string{}
+		staticConfigs := []*types.StaticConfig{}
+		for _, secretData := range secrets {
+			assert.LessOrEqual(t, secretDataSize(secretData), 250, encoding)
+
+			for _, key := range sortedDocumentNames(secretData) {
+				keys = append(keys, key)
+				if key == "other.yml" {
+					continue
+				}
+
+				var chunk []*types.StaticConfig
+				if encoding == config.JSONEncoding {
+					err = json.Unmarshal(secretData[key], &chunk)
+				} else {
+					err = yaml.Unmarshal(secretData[key], &chunk)
+				}
+				assert.NoError(t, err, encoding)
+				staticConfigs = append(staticConfigs, chunk...)
+			}
+		}
+
+		assert.Equal(t, []string{"other.yml", "puppetdb-sd-0.yml", "puppetdb-sd-1.yml", "puppetdb-sd-2.yml"}, keys, encoding)
+		assert.Equal(t, []*types.StaticConfig{
+			scrapeConfigs[0][0].StaticConfigs[0],
+			scrapeConfigs[0][0].StaticConfigs[1],
+			scrapeConfigs[0][1].StaticConfigs[0],
+		}, staticConfigs, encoding)
+	}
+}
+
+func TestSplitSecretDataErrors(t *testing.T) {
+	_, err := splitSecretData(map[string][]byte{
+		"prometheus.yml": []byte("global:\n  scrape_interval: 30s\n"),
+	}, config.YAMLEncoding, 20)
+	assert.ErrorContains(t, err, "failed to split key 'prometheus.yml' (document is not a list")
+
+	_, err = splitSecretData(map[string][]byte{
+		"puppetdb-sd.yml": []byte("- targets:\n  - server-1.example.com:9100\n"),
+	}, config.YAMLEncoding, 30)
+	assert.EqualError(t, err, "failed to split key 'puppetdb-sd.yml' (an item of 41 bytes is larger than the maximum size)")
+}
+
+func TestK8sSecretWriteOutputSplitSecrets(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	// Secret named like a split secret, not written by the output
+	foreign := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "prometheus-puppetdb-sd-out-3",
+			Namespace: "monitoring",
+		},
+	}
+
+	o := K8sSecretOutput{
+		k8sClient:     newApplyClientset(foreign),
+		secretName:    "prometheus-puppetdb-sd-out",
+		namespace:     "monitoring",
+		objectLabels:  map[string]string{"app.kubernetes.io/name": "prometheus-puppetdb-sd"},
+		secretKey:     "puppetdb-sd.yml",
+		splitSecrets:  true,
+		maxSecretSize: 250,
+		format:        config.MergedStaticConfigs,
+	}
+
+	listSecrets := func() (names []string) {
+		secrets, err := o.k8sClient.CoreV1().Secrets(o.namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			assert.FailNow(t, "Failed to list secrets", err.Error())
+		}
+
+		for _, secret := range secrets.Items {
+			names = append(names, secret.Name)
+		}
+		return
+	}
+
+	for i, expectedCount := range []int{3, 2} {
+		err := o.WriteOutput(ctx, scrapeConfigs[i])
+		if err != nil {
+			assert.FailNow(t, "Failed to write output", err.Error())
+		}
+
+		expectedNames := []string{"prometheus-puppetdb-sd-out-3"}
+		for j := 0; j < expectedCount; j++ {
+			expectedNames = append(expectedNames, fmt.Sprintf("prometheus-puppetdb-sd-out-%d", j))
+		}
+		assert.ElementsMatch(t, expectedNames, listSecrets())
+	}
+
+	o.splitSecrets = false
+	err := o.WriteOutput(ctx, scrapeConfigs[0])
+	assert.EqualError(t, err, "data of secret 'prometheus-puppetdb-sd-out' is 409 bytes, over the maximum secret size of 250 bytes")
+
+	// Split secrets are deleted once the output is no longer split
+	o.maxSecretSize = 1000
+	err = o.WriteOutput(ctx, scrapeConfigs[0])
+	if err != nil {
+		assert.FailNow(t, "Failed to write output", err.Error())
+	}
+	assert.ElementsMatch(t, []string{"prometheus-puppetdb-sd-out", "prometheus-puppetdb-sd-out-3"}, listSecrets())
+}
@@ -0,0 +1,139 @@
+package outputs
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/config"
+)
+
+// extraSecretSyncTimeout is how long a write waits for the extra config
+// secret to be cached before retrieving it from the API server instead
+const extraSecretSyncTimeout = 30 * time.Second
+
+// Changes returns a channel receiving a value when the extra config secret
+// changes, so that the output is written again without waiting for the next
+// run. It never receives anything without an extra config secret.
+func (o *K8sSecretOutput) Changes() <-chan struct{} {
+	return o.changes
+}
+
+// watchExtraSecret starts an informer caching the extra config secret and
+// notifying its changes until ctx is done. It waits for the secret to be
+// cached, for at most extraSecretSyncTimeout.
+func (o *K8sSecretOutput) watchExtraSecret(ctx context.Context) (err error) {
+	if o.extraSecretInformer != nil && !o.extraSecretInformer.IsStopped() {
+		return
+	}
+
+	secrets := o.k8sClient.CoreV1().Secrets(o.namespace)
+	selector := fields.OneTermEqualSelector("metadata.name", o.extraSecretName).String()
+
+	informer := cache.NewSharedIndexInformer(&cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = selector
+			return secrets.List(ctx, options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = selector
+			return secrets.Watch(ctx, options)
+		},
+	}, &v1.Secret{}, 0, cache.Indexers{})
+
+	_, err = informer.AddEventHandler(cache.ResourceEventHandlerDetailedFuncs{
+		AddFunc: func(obj interface{}, isInInitialList bool) {
+			if !isInInitialList {
+				o.notifyExtraSecretChange(obj)
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			if oldObj.(*v1.Secret).ResourceVersion != newObj.(*v1.Secret).ResourceVersion {
+				o.notifyExtraSecretChange(newObj)
+			}
+		},
+		DeleteFunc: o.notifyExtraSecretChange,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to watch extra secret '%s' (%s)", o.extraSecretName, err)
+	}
+
+	o.extraSecretInformer = informer
+	go informer.Run(ctx.Done())
+
+	syncCtx, cancelFunc := context.WithTimeout(ctx, extraSecretSyncTimeout)
+	defer cancelFunc()
+
+	if !cache.WaitForCacheSync(syncCtx.Done(), informer.HasSynced) {
+		log.Warnf("Extra secret '%s' is not cached yet, retrieving it from the API server", o.extraSecretName)
+	}
+	return
+}
+
+// notifyExtraSecretChange notifies a change of the extra config secret,
+// unless a change is already pending
+func (o *K8sSecretOutput) notifyExtraSecretChange(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil || key != o.namespace+"/"+o.extraSecretName {
+		return
+	}
+
+	log.Infof("Extra secret '%s' changed", o.extraSecretName)
+
+	select {
+	case o.changes <- struct{}{}:
+	default:
+	}
+}
+
+// getExtraConfigContent returns the content of the extra config secret, from
+// the informer cache once it is synced
+func (o *K8sSecretOutput) getExtraConfigContent(ctx context.Context) (content []byte, err error) {
+	if o.extraSecretName == "" || o.extraSecretKey == "" {
+		return
+	}
+
+	err = o.watchExtraSecret(ctx)
+	if err != nil {
+		return
+	}
+
+	var extraSecret *v1.Secret
+	if o.extraSecretInformer.HasSynced() {
+		obj, exists, _ := o.extraSecretInformer.GetStore().GetByKey(o.namespace + "/" + o.extraSecretName)
+		if exists {
+			extraSecret = obj.(*v1.Secret)
+		} else {
+			err = apierrors.NewNotFound(v1.Resource("secrets"), o.extraSecretName)
+		}
+	} else {
+		extraSecret, err = o.k8sClient.CoreV1().Secrets(o.namespace).Get(ctx, o.extraSecretName, metav1.GetOptions{})
+	}
+
+	if apierrors.IsNotFound(err) && o.missingExtraConfig == config.IgnoreMissingConfig {
+		log.Warnf("Extra secret '%s' not found, writing output without extra config", o.extraSecretName)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, k8sError("retrieve", fmt.Sprintf("extra secret '%s'", o.extraSecretName), err)
+	}
+
+	content, ok := extraSecret.Data[o.extraSecretKey]
+	if !ok && o.missingExtraConfig == config.IgnoreMissingConfig {
+		log.Warnf("Extra secret '%s' has no key '%s', writing output without extra config", o.extraSecretName, o.extraSecretKey)
+		return nil, nil
+	}
+	if !ok {
+		return nil, fmt.Errorf("extra secret has no key '%s'", o.extraSecretKey)
+	}
+	return
+}
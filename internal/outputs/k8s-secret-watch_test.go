@@ -0,0 +1,152 @@
+package outputs
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/config"
+)
+
+func TestK8sSecretWatchExtraSecret(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	extraSecret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "prometheus-extra",
+			Namespace:       "monitoring",
+			ResourceVersion: "1",
+		},
+		Data: map[string][]byte{
+			"extra.yml": []byte("- job_name: extra-1\n"),
+		},
+	}
+
+	client := newApplyClientset(extraSecret.DeepCopy())
+
+	// Report when the informer watches, since the fake clientset drops the
+	// events occurring before
+	watching := make(chan struct{})
+	var once sync.Once
+	client.PrependWatchReactor("secrets", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		w, err := client.Tracker().Watch(action.GetResource(), action.GetNamespace())
+		once.Do(func() { close(watching) })
+		return true, w, err
+	})
+
+	o := K8sSecretOutput{
+		k8sClient:          client,
+		secretName:         "prometheus-puppetdb-sd-out",
+		namespace:          "monitoring",
+		secretKey:          "puppetdb-sd.yml",
+		extraSecretName:    "prometheus-extra",
+		extraSecretKey:     "extra.yml",
+		missingExtraConfig: config.FailMissingConfig,
+		format:             config.ScrapeConfigs,
+		changes:            make(chan struct{}, 1),
+	}
+
+	output := func() string {
+		secret, err := client.CoreV1().Secrets(o.namespace).Get(ctx, o.secretName, metav1.GetOptions{})
+		if err != nil {
+			assert.FailNow(t, "Failed to retrieve secret", err.Error())
+		}
+		return string(secret.Data[o.secretKey])
+	}
+
+	waitForChange := func() {
+		select {
+		case <-o.Changes():
+		case <-time.After(5 * time.Second):
+			assert.FailNow(t, "Extra secret change not notified")
+		}
+	}
+
+	err := o.WriteOutput(ctx, scrapeConfigs[1])
+	if err != nil {
+		assert.FailNow(t, "Failed to write output", err.Error())
+	}
+	assert.Contains(t, output(), "- job_name: extra-1\n")
+
+	select {
+	case <-watching:
+	case <-time.After(5 * time.Second):
+		assert.FailNow(t, "Extra secret not watched")
+	}
+
+	// The initial listing of the extra secret is not a change
+	assert.Len(t, o.Changes(), 0)
+
+	// Updates are read from the cache, without retrieving the secret. The fake
+	// clientset does not set resource versions.
+	extraSecret.ResourceVersion = "2"
+	extraSecret.Data["extra.yml"] = []byte("- job_name: extra-2\n")
+	_, err = client.CoreV1().Secrets(o.namespace).Update(ctx, extraSecret, metav1.UpdateOptions{})
+	if err != nil {
+		assert.FailNow(t, "Failed to update extra secret", err.Error())
+	}
+	waitForChange()
+
+	client.ClearActions()
+	err = o.WriteOutput(ctx, scrapeConfigs[1])
+	if err != nil {
+		assert.FailNow(t, "Failed to write output", err.Error())
+	}
+	assert.Contains(t, output(), "- job_name: extra-2\n")
+	for _, action := range client.Actions() {
+		assert.False(t, action.Matches("get", "secrets") && action.(k8stesting.GetAction).GetName() == "prometheus-extra")
+	}
+
+	err = client.CoreV1().Secrets(o.namespace).Delete(ctx, extraSecret.Name, metav1.DeleteOptions{})
+	if err != nil {
+		assert.FailNow(t, "Failed to delete extra secret", err.Error())
+	}
+	waitForChange()
+
+	err = o.WriteOutput(ctx, scrapeConfigs[1])
+	assert.ErrorContains(t, err, "failed to retrieve extra secret 'prometheus-extra', not found")
+	assert.Contains(t, output(), "- job_name: extra-2\n")
+
+	o.missingExtraConfig = config.IgnoreMissingConfig
+	err = o.WriteOutput(ctx, scrapeConfigs[1])
+	if err != nil {
+		assert.FailNow(t, "Failed to write output", err.Error())
+	}
+	assert.NotContains(t, output(), "- job_name: extra")
+}
+
+func TestK8sSecretMissingExtraSecretKey(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	o := K8sSecretOutput{
+		k8sClient: newApplyClientset(&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "prometheus-extra",
+				Namespace: "monitoring",
+			},
+		}),
+		secretName:         "prometheus-puppetdb-sd-out",
+		namespace:          "monitoring",
+		secretKey:          "puppetdb-sd.yml",
+		extraSecretName:    "prometheus-extra",
+		extraSecretKey:     "extra.yml",
+		missingExtraConfig: config.FailMissingConfig,
+		format:             config.ScrapeConfigs,
+	}
+
+	err := o.WriteOutput(ctx, scrapeConfigs[1])
+	assert.ErrorContains(t, err, "extra secret has no key 'extra.yml'")
+
+	o.missingExtraConfig = config.IgnoreMissingConfig
+	err = o.WriteOutput(ctx, scrapeConfigs[1])
+	assert.NoError(t, err)
+}
@@ -3,15 +3,17 @@ package outputs
 import (
 	"context"
 	"fmt"
-	"strings"
 
-	yaml "gopkg.in/yaml.v1"
-	v1 "k8s.io/api/core/v1"
+	log "github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	applycorev1 "k8s.io/client-go/applyconfigurations/core/v1"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/cache"
 
 	"github.com/camptocamp/prometheus-puppetdb-sd/internal/config"
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/metrics"
 	"github.com/camptocamp/prometheus-puppetdb-sd/internal/types"
 )
 
@@ -19,142 +21,215 @@ import (
 type K8sSecretOutput struct {
 	k8sClient kubernetes.Interface
 
-	secretName       string
-	namespace        string
-	objectLabels     map[string]string
-	secretKey        string
-	secretKeyPattern string
-	extraSecretName  string
-	extraSecretKey   string
-
-	format config.OutputFormat
+	secretName         string
+	namespace          string
+	objectLabels       map[string]string
+	secretKey          string
+	secretKeyPattern   string
+	extraSecretName    string
+	extraSecretKey     string
+	baseSecretName     string
+	baseSecretKey      string
+	missingExtraConfig config.MissingConfigPolicy
+	splitSecrets       bool
+	maxSecretSize      int
+
+	extraSecretInformer cache.SharedIndexInformer
+	changes             chan struct{}
+
+	format          config.OutputFormat
+	encoding        config.OutputEncoding
+	baseConfigFile  string
+	extraConfigFile string
 }
 
 func setupK8sSecretOutput(cfg *config.OutputConfig) (*K8sSecretOutput, error) {
 	o := &K8sSecretOutput{
-		secretName:       cfg.K8sSecret.SecretName,
-		namespace:        cfg.K8sSecret.Namespace,
-		objectLabels:     cfg.K8sSecret.ObjectLabels,
-		secretKey:        cfg.K8sSecret.SecretKey,
-		secretKeyPattern: cfg.K8sSecret.SecretKeyPattern,
-		extraSecretName:  cfg.K8sSecret.ExtraConfigSecretName,
-		extraSecretKey:   cfg.K8sSecret.ExtraConfigSecretKey,
+		secretName:         cfg.K8sSecret.SecretName,
+		namespace:          cfg.K8sSecret.Namespace,
+		objectLabels:       cfg.K8sSecret.ObjectLabels,
+		secretKey:          cfg.K8sSecret.SecretKey,
+		secretKeyPattern:   cfg.K8sSecret.SecretKeyPattern,
+		extraSecretName:    cfg.K8sSecret.ExtraConfigSecretName,
+		extraSecretKey:     cfg.K8sSecret.ExtraConfigSecretKey,
+		baseSecretName:     cfg.K8sSecret.BaseConfigSecretName,
+		baseSecretKey:      cfg.K8sSecret.BaseConfigSecretKey,
+		missingExtraConfig: cfg.K8sSecret.MissingExtraConfig,
+		splitSecrets:       cfg.K8sSecret.SplitSecrets,
+		maxSecretSize:      cfg.K8sSecret.MaxSecretSize,
+
+		format:          cfg.Format,
+		encoding:        cfg.Encoding,
+		baseConfigFile:  cfg.BaseConfigFile,
+		extraConfigFile: cfg.ExtraConfigFile,
+
+		changes: make(chan struct{}, 1),
+	}
 
-		format: cfg.Format,
+	if o.maxSecretSize <= 0 || o.maxSecretSize > maxK8sSecretSize {
+		return nil, fmt.Errorf("invalid maximum secret size %d (must be between 1 and %d bytes)", o.maxSecretSize, maxK8sSecretSize)
 	}
 
-	kubeconfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
-		clientcmd.NewDefaultClientConfigLoadingRules(),
-		&clientcmd.ConfigOverrides{},
-	)
+	err := o.renderer().validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid secret key pattern: %s", err)
+	}
 
-	config, err := kubeconfig.ClientConfig()
+	restConfig, namespace, err := k8sClientConfig(o.namespace)
 	if err != nil {
 		return nil, err
 	}
-	clientset, err := kubernetes.NewForConfig(config)
+	o.namespace = namespace
+
+	clientset, err := kubernetes.NewForConfig(restConfig)
 	if err != nil {
 		return nil, err
 	}
 	o.k8sClient = clientset
 
-	if o.namespace == "" {
-		namespace, _, err := kubeconfig.Namespace()
-		if err != nil {
-			return nil, fmt.Errorf("failed to retrieve namespace: %s", err)
-		}
-		o.namespace = namespace
-	}
-
 	return o, nil
 }
 
-// WriteOutput writes Prometheus configuration to a Kubernetes Secret
+// WriteOutput writes Prometheus configuration to a Kubernetes Secret, or to
+// several secrets when the output is split
 func (o *K8sSecretOutput) WriteOutput(ctx context.Context, scrapeConfigs []*types.ScrapeConfig) (err error) {
-	secret := v1.Secret{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:   o.secretName,
-			Labels: o.objectLabels,
-		},
+	r := o.renderer()
+
+	// Extra Secret
+	r.extraConfig, err = o.getExtraConfigContent(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve extra config content (%s)", err)
+	}
+
+	// Base config Secret
+	r.baseConfig, err = o.getBaseConfigContent(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve base config content (%s)", err)
 	}
 
-	// Output Secret
-	_, err = o.k8sClient.CoreV1().Secrets(o.namespace).Get(ctx, o.secretName, metav1.GetOptions{})
+	data, err := r.render(scrapeConfigs)
 	if err != nil {
-		_, err = o.k8sClient.CoreV1().Secrets(o.namespace).Create(ctx, &secret, metav1.CreateOptions{})
+		return
+	}
+
+	metrics.K8sSecretSizeRatio.Reset()
+
+	if !o.splitSecrets {
+		err = o.writeSecret(ctx, o.secretName, data)
 		if err != nil {
-			return fmt.Errorf("failed to create secret (%s)", err)
+			return
 		}
+		return o.deleteSplitSecrets(ctx, 0)
 	}
 
-	// Extra Secret
-	extraContent, err := o.getExtraConfigContent(ctx)
+	secrets, err := splitSecretData(data, o.encoding, o.maxSize())
 	if err != nil {
-		return fmt.Errorf("failed to retrieve extra config content (%s)", err)
+		return fmt.Errorf("failed to split secret (%s)", err)
 	}
 
-	secret.Data = map[string][]byte{}
+	for i, secretData := range secrets {
+		err = o.writeSecret(ctx, fmt.Sprintf("%s-%d", o.secretName, i), secretData)
+		if err != nil {
+			return
+		}
+	}
 
-	var c []byte
-	var mc []byte
+	return o.deleteSplitSecrets(ctx, len(secrets))
+}
 
-	switch o.format {
-	case config.ScrapeConfigs:
-		c, err = yaml.Marshal(scrapeConfigs)
+// deleteSplitSecrets deletes the split secrets from an index on, left over by
+// a larger or a split output. Secrets without the object labels are kept.
+func (o *K8sSecretOutput) deleteSplitSecrets(ctx context.Context, start int) (err error) {
+	client := o.k8sClient.CoreV1().Secrets(o.namespace)
+	selector := labels.SelectorFromSet(o.objectLabels)
+
+	for i := start; ; i++ {
+		name := fmt.Sprintf("%s-%d", o.secretName, i)
+
+		secret, err := client.Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
 		if err != nil {
-			return
+			return k8sError("retrieve", fmt.Sprintf("secret '%s'", name), err)
 		}
 
-		secret.Data[o.secretKey] = append(c, extraContent...)
-	case config.StaticConfigs, config.MergedStaticConfigs:
-		for _, scrapeConfig := range scrapeConfigs {
-			c, err = yaml.Marshal(scrapeConfig.StaticConfigs)
-			if err != nil {
-				return
-			}
-
-			if o.format == config.MergedStaticConfigs {
-				mc = append(mc, c...)
-			} else {
-				secret.Data[strings.Replace(o.secretKeyPattern, "*", scrapeConfig.JobName, 1)] = c
-			}
+		if !selector.Matches(labels.Set(secret.Labels)) {
+			log.Warnf("secret '%s' does not have the object labels, keeping it", name)
+			continue
 		}
 
-		if o.format == config.MergedStaticConfigs {
-			secret.Data[o.secretKey] = append(mc, extraContent...)
+		err = client.Delete(ctx, name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return k8sError("delete", fmt.Sprintf("secret '%s'", name), err)
 		}
-	default:
-		err = fmt.Errorf("unexpected output format '%s'", o.format)
+	}
+}
 
-		return
+// writeSecret writes data to a secret with server-side apply, reporting its
+// size
+func (o *K8sSecretOutput) writeSecret(ctx context.Context, name string, data map[string][]byte) (err error) {
+	size := secretDataSize(data)
+	ratio := float64(size) / float64(o.maxSize())
+	metrics.K8sSecretSizeRatio.WithLabelValues(name).Set(ratio)
+
+	if ratio > 1 {
+		return fmt.Errorf("data of secret '%s' is %d bytes, over the maximum secret size of %d bytes", name, size, o.maxSize())
 	}
+	if ratio >= secretSizeWarningRatio {
+		log.Warnf("data of secret '%s' is %d bytes, %.0f%% of the maximum secret size", name, size, ratio*100)
+	}
+
+	secret := applycorev1.Secret(name, o.namespace).
+		WithLabels(o.objectLabels).
+		WithData(data)
 
-	_, err = o.k8sClient.CoreV1().Secrets(o.namespace).Update(ctx, &secret, metav1.UpdateOptions{})
+	_, err = o.k8sClient.CoreV1().Secrets(o.namespace).Apply(ctx, secret, metav1.ApplyOptions{
+		FieldManager: fieldManager,
+		Force:        true,
+	})
 	if err != nil {
-		return fmt.Errorf("failed to update secret (%s)", err)
+		return k8sError("apply", fmt.Sprintf("secret '%s'", name), err)
 	}
 
 	return
 }
 
-// getExtraConfigContent returns the content of the extra config secret
-func (o *K8sSecretOutput) getExtraConfigContent(ctx context.Context) (content []byte, err error) {
-	var extraContent string
+// maxSize returns the maximum size of the data of a secret
+func (o *K8sSecretOutput) maxSize() int {
+	if o.maxSecretSize == 0 {
+		return maxK8sSecretSize
+	}
+	return o.maxSecretSize
+}
+
+// renderer returns the renderer of the secret keys
+func (o *K8sSecretOutput) renderer() *renderer {
+	return &renderer{
+		format:          o.format,
+		encoding:        o.encoding,
+		name:            o.secretKey,
+		pattern:         o.secretKeyPattern,
+		baseConfigFile:  o.baseConfigFile,
+		extraConfigFile: o.extraConfigFile,
+	}
+}
 
-	if o.extraSecretName == "" || o.extraSecretKey == "" {
+// getBaseConfigContent returns the content of the base config secret, which
+// is only read with the prometheus-config format
+func (o *K8sSecretOutput) getBaseConfigContent(ctx context.Context) (content []byte, err error) {
+	if o.format != config.PrometheusConfig || o.baseSecretName == "" {
 		return
 	}
 
-	extraSecret, err := o.k8sClient.CoreV1().Secrets(o.namespace).Get(ctx, o.extraSecretName, metav1.GetOptions{})
+	baseSecret, err := o.k8sClient.CoreV1().Secrets(o.namespace).Get(ctx, o.baseSecretName, metav1.GetOptions{})
 	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve extra secret (%s)", err)
+		return nil, k8sError("retrieve", fmt.Sprintf("base config secret '%s'", o.baseSecretName), err)
 	}
 
-	if val, ok := extraSecret.Data[o.extraSecretKey]; ok {
-		extraContent = string(val)
-	} else {
-		return nil, fmt.Errorf("failed to retrieve extra secret content (%s)", err)
+	content, ok := baseSecret.Data[o.baseSecretKey]
+	if !ok {
+		return nil, fmt.Errorf("base config secret has no key '%s'", o.baseSecretKey)
 	}
-	content = []byte("\n" + extraContent)
 	return
 }
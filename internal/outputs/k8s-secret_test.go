@@ -2,20 +2,26 @@ package outputs
 
 import (
 	"context"
+	"errors"
 	"strings"
 	"testing"
 
-	"github.com/camptocamp/prometheus-puppetdb-sd/internal/config"
 	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	testclient "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/config"
 )
 
 func (o *K8sSecretOutput) testK8sSecretWriteOutput(t *testing.T) {
 	ctx, cancelFunc := context.WithCancel(context.Background())
 	defer cancelFunc()
 
-	o.k8sClient = testclient.NewSimpleClientset()
+	o.k8sClient = newApplyClientset()
 
 	o.secretName = "prometheus-puppetdb-sd-out"
 	o.namespace = "monitoring"
@@ -95,3 +101,115 @@ func TestK8sSecretWriteOutputMergedStaticConfigsSuccess(t *testing.T) {
 
 	o.testK8sSecretWriteOutput(t)
 }
+
+func TestK8sSecretWriteOutputPrometheusConfigBaseSecret(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	o := K8sSecretOutput{
+		k8sClient: newApplyClientset(&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "prometheus-base",
+				Namespace: "monitoring",
+			},
+			Data: map[string][]byte{
+				"prometheus.yml": []byte(testBaseConfig),
+			},
+		}),
+		secretName:     "prometheus-puppetdb-sd-out",
+		namespace:      "monitoring",
+		secretKey:      "prometheus.yml",
+		baseSecretName: "prometheus-base",
+		baseSecretKey:  "prometheus.yml",
+		format:         config.PrometheusConfig,
+	}
+
+	err := o.WriteOutput(ctx, scrapeConfigs[1])
+	if err != nil {
+		assert.FailNow(t, "Failed to write output", err.Error())
+	}
+
+	secret, err := o.k8sClient.CoreV1().Secrets(o.namespace).Get(ctx, o.secretName, metav1.GetOptions{})
+	if err != nil {
+		assert.FailNow(t, "Failed to retrieve secret", err.Error())
+	}
+
+	output := string(secret.Data["prometheus.yml"])
+	assert.Contains(t, output, "scrape_interval: 30s\n")
+	assert.Contains(t, output, "- job_name: prometheus\n")
+	assert.Contains(t, output, "- job_name: node-exporter\n")
+
+	o.baseSecretKey = "missing.yml"
+	err = o.WriteOutput(ctx, scrapeConfigs[1])
+	assert.Error(t, err)
+}
+
+func TestK8sSecretWriteOutputKeepsOtherFields(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	o := K8sSecretOutput{
+		k8sClient: newApplyClientset(&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "prometheus-puppetdb-sd-out",
+				Namespace:   "monitoring",
+				Labels:      map[string]string{"team": "team-1"},
+				Annotations: map[string]string{"owner": "team-1"},
+			},
+			Data: map[string][]byte{
+				"other.yml": []byte("[]\n"),
+			},
+		}),
+		secretName: "prometheus-puppetdb-sd-out",
+		namespace:  "monitoring",
+		objectLabels: map[string]string{
+			"app.kubernetes.io/name": "prometheus-puppetdb-sd",
+		},
+		secretKeyPattern: "*.yml",
+		format:           config.StaticConfigs,
+	}
+
+	for i := range scrapeConfigs {
+		err := o.WriteOutput(ctx, scrapeConfigs[i])
+		if err != nil {
+			assert.FailNow(t, "Failed to write output", err.Error())
+		}
+	}
+
+	secret, err := o.k8sClient.CoreV1().Secrets(o.namespace).Get(ctx, o.secretName, metav1.GetOptions{})
+	if err != nil {
+		assert.FailNow(t, "Failed to retrieve secret", err.Error())
+	}
+
+	keys := []string{}
+	for key := range secret.Data {
+		keys = append(keys, key)
+	}
+	assert.ElementsMatch(t, []string{"node-exporter.yml", "other.yml"}, keys)
+	assert.Equal(t, map[string]string{
+		"app.kubernetes.io/name": "prometheus-puppetdb-sd",
+		"team":                   "team-1",
+	}, secret.Labels)
+	assert.Equal(t, map[string]string{"owner": "team-1"}, secret.Annotations)
+}
+
+func TestK8sSecretWriteOutputForbidden(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	client := newApplyClientset()
+	client.PrependReactor("patch", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(schema.GroupResource{Resource: "secrets"}, "prometheus-puppetdb-sd-out", errors.New("denied"))
+	})
+
+	o := K8sSecretOutput{
+		k8sClient:  client,
+		secretName: "prometheus-puppetdb-sd-out",
+		namespace:  "monitoring",
+		secretKey:  "puppetdb-sd.yml",
+		format:     config.ScrapeConfigs,
+	}
+
+	err := o.WriteOutput(ctx, scrapeConfigs[0])
+	assert.ErrorContains(t, err, "failed to apply secret 'prometheus-puppetdb-sd-out', permission denied")
+}
@@ -0,0 +1,483 @@
+package outputs
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	applycorev1 "k8s.io/client-go/applyconfigurations/core/v1"
+	applydiscoveryv1 "k8s.io/client-go/applyconfigurations/discovery/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/config"
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/types"
+)
+
+const (
+	// maxEndpointsPerSlice is the maximum number of endpoints accepted by the
+	// API server in an EndpointSlice
+	maxEndpointsPerSlice = 1000
+
+	// maxPortNameLength leaves room for a port number suffix in the 15
+	// characters of a port name
+	maxPortNameLength = 9
+
+	serviceJobLabel      = "prometheus-puppetdb-sd/job"
+	serviceJobAnnotation = "prometheus-puppetdb-sd/job"
+	endpointSliceManager = "prometheus-puppetdb-sd"
+)
+
+// K8sServiceOutput stores data needed to manage headless Services and their
+// EndpointSlices
+type K8sServiceOutput struct {
+	k8sClient kubernetes.Interface
+
+	namespace    string
+	objectLabels map[string]string
+	namePrefix   string
+	portName     string
+}
+
+// endpointGroup gathers the addresses sharing the same labels, address type
+// and port, which end up in the same EndpointSlices
+type endpointGroup struct {
+	labels      map[string]string
+	addressType discoveryv1.AddressType
+	port        int32
+	addresses   []string
+}
+
+func setupK8sServiceOutput(cfg *config.OutputConfig) (*K8sServiceOutput, error) {
+	o := &K8sServiceOutput{
+		namespace:    cfg.K8sService.Namespace,
+		objectLabels: cfg.K8sService.ObjectLabels,
+		namePrefix:   cfg.K8sService.NamePrefix,
+		portName:     cfg.K8sService.PortName,
+	}
+
+	if len(o.objectLabels) == 0 {
+		return nil, fmt.Errorf("object labels are required to select the objects to garbage-collect")
+	}
+
+	if len(o.portName) > maxPortNameLength || len(validation.IsValidPortName(o.portName)) > 0 {
+		return nil, fmt.Errorf("invalid port name '%s' (must be a valid port name of at most %d characters)", o.portName, maxPortNameLength)
+	}
+
+	restConfig, namespace, err := k8sClientConfig(o.namespace)
+	if err != nil {
+		return nil, err
+	}
+	o.namespace = namespace
+
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	o.k8sClient = clientset
+
+	return o, nil
+}
+
+// WriteOutput writes the targets of each job to a headless Service and its
+// EndpointSlices, and deletes the objects of the jobs which disappeared
+func (o *K8sServiceOutput) WriteOutput(ctx context.Context, scrapeConfigs []*types.ScrapeConfig) (err error) {
+	names := objectNames(o.namePrefix, scrapeConfigs, sanitizeServiceName, maxServiceNameLength)
+
+	services := make(map[string]struct{}, len(scrapeConfigs))
+	endpointSlices := map[string]struct{}{}
+
+	for _, scrapeConfig := range scrapeConfigs {
+		if unsupported := unsupportedServiceSettings(scrapeConfig); len(unsupported) > 0 {
+			log.Warnf("job '%s' has %s, which a Service cannot represent, skipping it", scrapeConfig.JobName, strings.Join(unsupported, ", "))
+			continue
+		}
+
+		name := names[scrapeConfig.JobName]
+		groups := o.endpointGroups(scrapeConfig)
+		if len(groups) == 0 {
+			log.Warnf("job '%s' has no target usable in an EndpointSlice, skipping its Service", scrapeConfig.JobName)
+			continue
+		}
+
+		err = o.applyService(ctx, o.service(name, scrapeConfig.JobName, groups))
+		if err != nil {
+			return
+		}
+		services[name] = struct{}{}
+
+		for _, endpointSlice := range o.endpointSlices(name, groups) {
+			err = o.applyEndpointSlice(ctx, endpointSlice)
+			if err != nil {
+				return
+			}
+			endpointSlices[endpointSlice.Name] = struct{}{}
+		}
+	}
+
+	return o.garbageCollect(ctx, services, endpointSlices)
+}
+
+// unsupportedServiceSettings returns the settings and target labels of a job
+// which cannot be carried by a Service and its EndpointSlices
+func unsupportedServiceSettings(scrapeConfig *types.ScrapeConfig) (unsupported []string) {
+	if scrapeConfig.Scheme != "" && scrapeConfig.Scheme != "http" {
+		unsupported = append(unsupported, "scheme")
+	}
+	if scrapeConfig.MetricsPath != "" && scrapeConfig.MetricsPath != "/metrics" {
+		unsupported = append(unsupported, "metrics_path")
+	}
+	if len(scrapeConfig.Params) > 0 {
+		unsupported = append(unsupported, "params")
+	}
+	if scrapeConfig.ProxyURL != "" {
+		unsupported = append(unsupported, "proxy_url")
+	}
+	if scrapeConfig.TLSConfig != nil {
+		unsupported = append(unsupported, "tls_config")
+	}
+	if scrapeConfig.BasicAuth != nil {
+		unsupported = append(unsupported, "basic_auth")
+	}
+
+	reserved := map[string]struct{}{}
+	for _, staticConfig := range scrapeConfig.StaticConfigs {
+		for name := range staticConfig.Labels {
+			if strings.HasPrefix(name, "__") {
+				reserved[name] = struct{}{}
+			}
+		}
+	}
+
+	names := make([]string, 0, len(reserved))
+	for name := range reserved {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		unsupported = append(unsupported, fmt.Sprintf("target label '%s'", name))
+	}
+	return
+}
+
+// endpointGroups groups the targets of a job by labels, address type and
+// port. Targets without a port or with an address not allowed in an
+// EndpointSlice are skipped.
+func (o *K8sServiceOutput) endpointGroups(scrapeConfig *types.ScrapeConfig) (groups []*endpointGroup) {
+	index := map[string]*endpointGroup{}
+
+	for _, staticConfig := range scrapeConfig.StaticConfigs {
+		objectLabels := o.endpointSliceLabels(staticConfig.Labels)
+
+		for _, target := range staticConfig.Targets {
+			host, portValue, err := net.SplitHostPort(target)
+			if err != nil {
+				log.Warnf("target '%s' of job '%s' has no port, skipping it (%s)", target, scrapeConfig.JobName, err)
+				continue
+			}
+
+			port, err := strconv.ParseInt(portValue, 10, 32)
+			if err != nil || port < 1 || port > 65535 {
+				log.Warnf("target '%s' of job '%s' has an invalid port, skipping it", target, scrapeConfig.JobName)
+				continue
+			}
+
+			address, addressType := endpointAddress(host)
+			if addressType == "" {
+				log.Warnf("target '%s' of job '%s' is not a valid IP address or FQDN, skipping it", target, scrapeConfig.JobName)
+				continue
+			}
+
+			key := fmt.Sprintf("%s/%d/%s", addressType, port, labels.Set(objectLabels).String())
+			group, ok := index[key]
+			if !ok {
+				group = &endpointGroup{
+					labels:      objectLabels,
+					addressType: addressType,
+					port:        int32(port),
+				}
+				index[key] = group
+			}
+			group.addresses = append(group.addresses, address)
+		}
+	}
+
+	keys := make([]string, 0, len(index))
+	for key := range index {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		groups = append(groups, index[key])
+	}
+	return
+}
+
+// endpointAddress returns the EndpointSlice address and address type of a
+// target host, or an empty address type when the host cannot be used
+func endpointAddress(host string) (address string, addressType discoveryv1.AddressType) {
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.To4() != nil {
+			return ip.String(), discoveryv1.AddressTypeIPv4
+		}
+		return ip.String(), discoveryv1.AddressTypeIPv6
+	}
+
+	address = strings.ToLower(strings.TrimSuffix(host, "."))
+	if len(validation.IsDNS1123Subdomain(address)) > 0 {
+		return "", ""
+	}
+	return address, discoveryv1.AddressTypeFQDN
+}
+
+// endpointSliceLabels converts static config labels to EndpointSlice labels.
+// Labels which are not valid Kubernetes labels are dropped.
+func (o *K8sServiceOutput) endpointSliceLabels(staticLabels map[string]string) (objectLabels map[string]string) {
+	objectLabels = make(map[string]string, len(staticLabels))
+
+	for name, value := range staticLabels {
+		if len(validation.IsQualifiedName(name)) > 0 || len(validation.IsValidLabelValue(value)) > 0 {
+			log.Debugf("label '%s=%s' is not a valid Kubernetes label, dropping it from EndpointSlices", name, value)
+			continue
+		}
+		objectLabels[name] = value
+	}
+	return
+}
+
+// servicePortName returns the name of a Service port
+func (o *K8sServiceOutput) servicePortName(port int32, multiplePorts bool) string {
+	if !multiplePorts {
+		return o.portName
+	}
+	return fmt.Sprintf("%s-%d", o.portName, port)
+}
+
+// service builds the headless Service of a job
+func (o *K8sServiceOutput) service(name, jobName string, groups []*endpointGroup) *v1.Service {
+	ports := map[int32]struct{}{}
+	for _, group := range groups {
+		ports[group.port] = struct{}{}
+	}
+
+	service := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   o.namespace,
+			Labels:      o.serviceLabels(jobName, groups),
+			Annotations: map[string]string{serviceJobAnnotation: jobName, ownerAnnotation: o.namePrefix},
+		},
+		Spec: v1.ServiceSpec{
+			ClusterIP: v1.ClusterIPNone,
+		},
+	}
+
+	for port := range ports {
+		service.Spec.Ports = append(service.Spec.Ports, v1.ServicePort{
+			Name:     o.servicePortName(port, len(ports) > 1),
+			Protocol: v1.ProtocolTCP,
+			Port:     port,
+		})
+	}
+	sort.Slice(service.Spec.Ports, func(i, j int) bool {
+		return service.Spec.Ports[i].Port < service.Spec.Ports[j].Port
+	})
+
+	return service
+}
+
+// serviceLabels returns the labels of a Service: the labels shared by all the
+// EndpointSlice groups, the job name when it is a valid label value, and the
+// object labels
+func (o *K8sServiceOutput) serviceLabels(jobName string, groups []*endpointGroup) map[string]string {
+	serviceLabels := map[string]string{}
+	for name, value := range groups[0].labels {
+		serviceLabels[name] = value
+	}
+	for _, group := range groups[1:] {
+		for name, value := range serviceLabels {
+			if group.labels[name] != value {
+				delete(serviceLabels, name)
+			}
+		}
+	}
+
+	if len(validation.IsValidLabelValue(jobName)) == 0 {
+		serviceLabels[serviceJobLabel] = jobName
+	}
+	for name, value := range o.objectLabels {
+		serviceLabels[name] = value
+	}
+	return serviceLabels
+}
+
+// endpointSlices builds the EndpointSlices of a Service, splitting the groups
+// larger than the maximum size of an EndpointSlice
+func (o *K8sServiceOutput) endpointSlices(serviceName string, groups []*endpointGroup) (endpointSlices []*discoveryv1.EndpointSlice) {
+	ports := map[int32]struct{}{}
+	for _, group := range groups {
+		ports[group.port] = struct{}{}
+	}
+
+	for _, group := range groups {
+		portName := o.servicePortName(group.port, len(ports) > 1)
+		protocol := v1.ProtocolTCP
+		port := group.port
+
+		groupKey := fmt.Sprintf("%s/%d/%s", group.addressType, group.port, labels.Set(group.labels).String())
+
+		for start := 0; start < len(group.addresses); start += maxEndpointsPerSlice {
+			addresses := group.addresses[start:min(start+maxEndpointsPerSlice, len(group.addresses))]
+
+			sliceLabels := make(map[string]string, len(group.labels)+len(o.objectLabels)+2)
+			for name, value := range group.labels {
+				sliceLabels[name] = value
+			}
+			for name, value := range o.objectLabels {
+				sliceLabels[name] = value
+			}
+			sliceLabels[discoveryv1.LabelServiceName] = serviceName
+			sliceLabels[discoveryv1.LabelManagedBy] = endpointSliceManager
+
+			endpointSlice := &discoveryv1.EndpointSlice{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        fmt.Sprintf("%s-%s", serviceName, shortHash(fmt.Sprintf("%s/%d", groupKey, start))),
+					Namespace:   o.namespace,
+					Labels:      sliceLabels,
+					Annotations: map[string]string{ownerAnnotation: o.namePrefix},
+				},
+				AddressType: group.addressType,
+				Ports: []discoveryv1.EndpointPort{
+					{Name: &portName, Protocol: &protocol, Port: &port},
+				},
+			}
+
+			ready := true
+			for _, address := range addresses {
+				endpointSlice.Endpoints = append(endpointSlice.Endpoints, discoveryv1.Endpoint{
+					Addresses:  []string{address},
+					Conditions: discoveryv1.EndpointConditions{Ready: &ready},
+				})
+			}
+
+			endpointSlices = append(endpointSlices, endpointSlice)
+		}
+	}
+	return
+}
+
+// applyService writes a Service with server-side apply
+func (o *K8sServiceOutput) applyService(ctx context.Context, service *v1.Service) (err error) {
+	spec := applycorev1.ServiceSpec().WithClusterIP(service.Spec.ClusterIP)
+	for _, port := range service.Spec.Ports {
+		spec.WithPorts(applycorev1.ServicePort().
+			WithName(port.Name).
+			WithProtocol(port.Protocol).
+			WithPort(port.Port))
+	}
+
+	serviceApply := applycorev1.Service(service.Name, service.Namespace).
+		WithLabels(service.Labels).
+		WithAnnotations(service.Annotations).
+		WithSpec(spec)
+
+	_, err = o.k8sClient.CoreV1().Services(o.namespace).Apply(ctx, serviceApply, metav1.ApplyOptions{
+		FieldManager: fieldManager,
+		Force:        true,
+	})
+	if err != nil {
+		return k8sError("apply", fmt.Sprintf("Service '%s'", service.Name), err)
+	}
+	return
+}
+
+// applyEndpointSlice writes an EndpointSlice with server-side apply
+func (o *K8sServiceOutput) applyEndpointSlice(ctx context.Context, endpointSlice *discoveryv1.EndpointSlice) (err error) {
+	endpointSliceApply := applydiscoveryv1.EndpointSlice(endpointSlice.Name, endpointSlice.Namespace).
+		WithLabels(endpointSlice.Labels).
+		WithAnnotations(endpointSlice.Annotations).
+		WithAddressType(endpointSlice.AddressType)
+
+	for _, port := range endpointSlice.Ports {
+		endpointSliceApply.WithPorts(applydiscoveryv1.EndpointPort().
+			WithName(*port.Name).
+			WithProtocol(*port.Protocol).
+			WithPort(*port.Port))
+	}
+
+	for _, endpoint := range endpointSlice.Endpoints {
+		endpointSliceApply.WithEndpoints(applydiscoveryv1.Endpoint().
+			WithAddresses(endpoint.Addresses...).
+			WithConditions(applydiscoveryv1.EndpointConditions().WithReady(*endpoint.Conditions.Ready)))
+	}
+
+	_, err = o.k8sClient.DiscoveryV1().EndpointSlices(o.namespace).Apply(ctx, endpointSliceApply, metav1.ApplyOptions{
+		FieldManager: fieldManager,
+		Force:        true,
+	})
+	if err != nil {
+		return k8sError("apply", fmt.Sprintf("EndpointSlice '%s'", endpointSlice.Name), err)
+	}
+	return
+}
+
+// garbageCollect deletes the Services and EndpointSlices selected by the
+// object labels and written with the name prefix which are not part of the
+// current output
+func (o *K8sServiceOutput) garbageCollect(ctx context.Context, services, endpointSlices map[string]struct{}) (err error) {
+	listOptions := metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(o.objectLabels).String(),
+	}
+
+	endpointSliceClient := o.k8sClient.DiscoveryV1().EndpointSlices(o.namespace)
+	endpointSliceList, err := endpointSliceClient.List(ctx, listOptions)
+	if err != nil {
+		return k8sError("list", "EndpointSlices", err)
+	}
+
+	for _, item := range endpointSliceList.Items {
+		if _, ok := endpointSlices[item.Name]; ok {
+			continue
+		}
+		if item.Labels[discoveryv1.LabelManagedBy] != endpointSliceManager || item.Annotations[ownerAnnotation] != o.namePrefix {
+			continue
+		}
+
+		err = endpointSliceClient.Delete(ctx, item.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return k8sError("delete", fmt.Sprintf("EndpointSlice '%s'", item.Name), err)
+		}
+	}
+
+	serviceClient := o.k8sClient.CoreV1().Services(o.namespace)
+	serviceList, err := serviceClient.List(ctx, listOptions)
+	if err != nil {
+		return k8sError("list", "Services", err)
+	}
+
+	for _, item := range serviceList.Items {
+		if _, ok := services[item.Name]; ok {
+			continue
+		}
+		if item.Annotations[ownerAnnotation] != o.namePrefix {
+			continue
+		}
+
+		err = serviceClient.Delete(ctx, item.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return k8sError("delete", fmt.Sprintf("Service '%s'", item.Name), err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,311 @@
+package outputs
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/types"
+)
+
+func testK8sServiceOutput() *K8sServiceOutput {
+	unmanaged := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "other",
+			Namespace: "monitoring",
+			Labels: map[string]string{
+				"app.kubernetes.io/name": "prometheus-puppetdb-sd",
+			},
+		},
+	}
+
+	return &K8sServiceOutput{
+		k8sClient: newApplyClientset(unmanaged),
+		namespace: "monitoring",
+		objectLabels: map[string]string{
+			"app.kubernetes.io/name": "prometheus-puppetdb-sd",
+		},
+		namePrefix: "puppetdb-sd-",
+		portName:   "metrics",
+	}
+}
+
+func TestK8sServiceWriteOutput(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	o := testK8sServiceOutput()
+
+	expectedServices := [][]string{
+		{"other", "puppetdb-sd-apache-exporter", "puppetdb-sd-node-exporter"},
+		{"other", "puppetdb-sd-node-exporter"},
+	}
+	expectedEndpointSlices := []int{3, 2}
+	expectedTeams := []string{"team-1", ""}
+
+	for i := range scrapeConfigs {
+		err := o.WriteOutput(ctx, scrapeConfigs[i])
+		if err != nil {
+			assert.FailNow(t, "Failed to write output", err.Error())
+		}
+
+		services, err := o.k8sClient.CoreV1().Services("monitoring").List(ctx, metav1.ListOptions{})
+		if err != nil {
+			assert.FailNow(t, "Failed to list Services", err.Error())
+		}
+
+		names := []string{}
+		for _, service := range services.Items {
+			names = append(names, service.Name)
+			if service.Name != "puppetdb-sd-node-exporter" {
+				continue
+			}
+
+			assert.Equal(t, v1.ClusterIPNone, service.Spec.ClusterIP)
+			assert.Nil(t, service.Spec.Selector)
+			assert.Equal(t, []v1.ServicePort{{Name: "metrics", Protocol: v1.ProtocolTCP, Port: 9100}}, service.Spec.Ports)
+			assert.Equal(t, "prometheus-puppetdb-sd", service.Labels["app.kubernetes.io/name"])
+			assert.Equal(t, "node-exporter", service.Labels["prometheus-puppetdb-sd/job"])
+			assert.Equal(t, expectedTeams[i], service.Labels["team"])
+			assert.NotContains(t, service.Labels, "certname")
+		}
+		assert.ElementsMatch(t, expectedServices[i], names)
+
+		endpointSlices, err := o.k8sClient.DiscoveryV1().EndpointSlices("monitoring").List(ctx, metav1.ListOptions{})
+		if err != nil {
+			assert.FailNow(t, "Failed to list EndpointSlices", err.Error())
+		}
+		assert.Len(t, endpointSlices.Items, expectedEndpointSlices[i])
+
+		for _, endpointSlice := range endpointSlices.Items {
+			if endpointSlice.Labels["certname"] != "server-2.example.com" {
+				continue
+			}
+
+			assert.Equal(t, "puppetdb-sd-node-exporter", endpointSlice.Labels[discoveryv1.LabelServiceName])
+			assert.Equal(t, "development", endpointSlice.Labels["environment"])
+			assert.Equal(t, discoveryv1.AddressTypeFQDN, endpointSlice.AddressType)
+			if assert.Len(t, endpointSlice.Endpoints, 1) {
+				assert.Equal(t, []string{"server-2.example.com"}, endpointSlice.Endpoints[0].Addresses)
+			}
+		}
+	}
+}
+
+func TestK8sServiceWriteOutputKeepsOtherFields(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	o := testK8sServiceOutput()
+	o.k8sClient = newApplyClientset(&v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "puppetdb-sd-node-exporter",
+			Namespace:   "monitoring",
+			Labels:      map[string]string{"owner": "team-1"},
+			Annotations: map[string]string{"owner": "team-1"},
+		},
+	})
+
+	for i := range scrapeConfigs {
+		err := o.WriteOutput(ctx, scrapeConfigs[i])
+		if err != nil {
+			assert.FailNow(t, "Failed to write output", err.Error())
+		}
+	}
+
+	service, err := o.k8sClient.CoreV1().Services("monitoring").Get(ctx, "puppetdb-sd-node-exporter", metav1.GetOptions{})
+	if err != nil {
+		assert.FailNow(t, "Failed to retrieve Service", err.Error())
+	}
+
+	assert.Equal(t, "team-1", service.Labels["owner"])
+	assert.Equal(t, "prometheus-puppetdb-sd", service.Labels["app.kubernetes.io/name"])
+	assert.NotContains(t, service.Labels, "team")
+	assert.Equal(t, map[string]string{
+		"owner":              "team-1",
+		serviceJobAnnotation: "node-exporter",
+		ownerAnnotation:      "puppetdb-sd-",
+	}, service.Annotations)
+	assert.Equal(t, v1.ClusterIPNone, service.Spec.ClusterIP)
+}
+
+func TestK8sServiceWriteOutputKeepsForeignObjects(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	// Objects of another instance with the same object labels
+	objectLabels := map[string]string{"app.kubernetes.io/name": "prometheus-puppetdb-sd"}
+	sliceLabels := map[string]string{
+		"app.kubernetes.io/name":     "prometheus-puppetdb-sd",
+		discoveryv1.LabelServiceName: "puppetdb-sd-dc2-node-exporter",
+		discoveryv1.LabelManagedBy:   endpointSliceManager,
+	}
+	annotations := map[string]string{
+		serviceJobAnnotation: "node-exporter",
+		ownerAnnotation:      "puppetdb-sd-dc2-",
+	}
+
+	o := testK8sServiceOutput()
+	o.k8sClient = newApplyClientset(
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "puppetdb-sd-dc2-node-exporter",
+				Namespace:   "monitoring",
+				Labels:      objectLabels,
+				Annotations: annotations,
+			},
+		},
+		&discoveryv1.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "puppetdb-sd-dc2-node-exporter-abcdef01",
+				Namespace:   "monitoring",
+				Labels:      sliceLabels,
+				Annotations: annotations,
+			},
+		},
+	)
+
+	for i := range scrapeConfigs {
+		err := o.WriteOutput(ctx, scrapeConfigs[i])
+		if err != nil {
+			assert.FailNow(t, "Failed to write output", err.Error())
+		}
+	}
+
+	_, err := o.k8sClient.CoreV1().Services("monitoring").Get(ctx, "puppetdb-sd-dc2-node-exporter", metav1.GetOptions{})
+	assert.NoError(t, err)
+	_, err = o.k8sClient.DiscoveryV1().EndpointSlices("monitoring").Get(ctx, "puppetdb-sd-dc2-node-exporter-abcdef01", metav1.GetOptions{})
+	assert.NoError(t, err)
+}
+
+func TestK8sServiceWriteOutputUnsupportedSettings(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	o := testK8sServiceOutput()
+
+	// A blackbox exporter probe scrapes the prober with the probed endpoint
+	// as parameter, which an EndpointSlice cannot carry
+	err := o.WriteOutput(ctx, []*types.ScrapeConfig{
+		{
+			JobName: "http-probe",
+			ScrapeSettings: types.ScrapeSettings{
+				MetricsPath: "/probe",
+			},
+			StaticConfigs: []*types.StaticConfig{
+				{
+					Targets: []string{"blackbox-exporter.example.com:9115"},
+					Labels: map[string]string{
+						"__param_module": "http_2xx",
+						"__param_target": "https://www.example.com",
+						"instance":       "https://www.example.com",
+					},
+				},
+				{
+					Targets: []string{"blackbox-exporter.example.com:9115"},
+					Labels: map[string]string{
+						"__param_module": "http_2xx",
+						"__param_target": "https://www.example.org",
+						"instance":       "https://www.example.org",
+					},
+				},
+			},
+		},
+		{
+			JobName: "node-exporter",
+			ScrapeSettings: types.ScrapeSettings{
+				Scheme:      "http",
+				MetricsPath: "/metrics",
+			},
+			StaticConfigs: []*types.StaticConfig{
+				{Targets: []string{"server-1.example.com:9100"}},
+			},
+		},
+	})
+	if err != nil {
+		assert.FailNow(t, "Failed to write output", err.Error())
+	}
+
+	services, err := o.k8sClient.CoreV1().Services("monitoring").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		assert.FailNow(t, "Failed to list Services", err.Error())
+	}
+
+	names := []string{}
+	for _, service := range services.Items {
+		names = append(names, service.Name)
+	}
+	assert.ElementsMatch(t, []string{"other", "puppetdb-sd-node-exporter"}, names)
+
+	assert.Equal(t, []string{
+		"scheme",
+		"metrics_path",
+		"params",
+		"proxy_url",
+		"tls_config",
+		"basic_auth",
+		"target label '__param_target'",
+		"target label '__scheme__'",
+	}, unsupportedServiceSettings(&types.ScrapeConfig{
+		JobName: "node-exporter",
+		ScrapeSettings: types.ScrapeSettings{
+			Scheme:      "https",
+			MetricsPath: "/node/metrics",
+			Params:      map[string][]string{"collect[]": {"cpu"}},
+			BasicAuth:   &types.BasicAuth{Username: "prometheus"},
+			TLSConfig:   &types.TLSConfig{CAFile: "/etc/prometheus/ca.pem"},
+		},
+		ProxyURL: "http://proxy.example.com:3128",
+		StaticConfigs: []*types.StaticConfig{
+			{Labels: map[string]string{"__scheme__": "https", "team": "team-1"}},
+			{Labels: map[string]string{"__param_target": "a", "__scheme__": "https"}},
+		},
+	}))
+}
+
+func TestK8sServiceEndpointGroups(t *testing.T) {
+	o := testK8sServiceOutput()
+
+	groups := o.endpointGroups(&types.ScrapeConfig{
+		JobName: "node-exporter",
+		StaticConfigs: []*types.StaticConfig{
+			{
+				Targets: []string{"192.168.0.1:9100", "192.168.0.2:9100", "[2001:db8::1]:9100", "Server-1.Example.com.:9100"},
+				Labels:  map[string]string{"team": "team-1", "__scheme__": "https"},
+			},
+			{
+				Targets: []string{"server-2.example.com:9101", "server-3.example.com", "server_4.example.com:9100"},
+				Labels:  map[string]string{"team": "team-1"},
+			},
+		},
+	})
+
+	if assert.Len(t, groups, 4) {
+		assert.Equal(t, &endpointGroup{
+			labels:      map[string]string{"team": "team-1"},
+			addressType: discoveryv1.AddressTypeFQDN,
+			port:        9100,
+			addresses:   []string{"server-1.example.com"},
+		}, groups[0])
+		assert.Equal(t, int32(9101), groups[1].port)
+		assert.Equal(t, []string{"192.168.0.1", "192.168.0.2"}, groups[2].addresses)
+		assert.Equal(t, discoveryv1.AddressTypeIPv6, groups[3].addressType)
+	}
+
+	service := o.service("puppetdb-sd-node-exporter", "node-exporter", groups)
+	assert.Equal(t, []v1.ServicePort{
+		{Name: "metrics-9100", Protocol: v1.ProtocolTCP, Port: 9100},
+		{Name: "metrics-9101", Protocol: v1.ProtocolTCP, Port: 9101},
+	}, service.Spec.Ports)
+}
+
+func TestSanitizeServiceName(t *testing.T) {
+	assert.Equal(t, "puppetdb-sd-node-exporter", sanitizeServiceName("puppetdb-sd-node_exporter"))
+	assert.Equal(t, "s-9100-exporter", sanitizeServiceName("9100.exporter"))
+	assert.Len(t, sanitizeServiceName("puppetdb-sd-"+strings.Repeat("a", 100)), maxServiceNameLength)
+}
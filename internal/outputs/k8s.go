@@ -0,0 +1,140 @@
+package outputs
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/types"
+)
+
+// fieldManager is the field manager of the objects written with server-side
+// apply. Only the fields written by its previous apply are replaced, so that
+// keys, labels, annotations and other fields set by others are kept.
+const fieldManager = "prometheus-puppetdb-sd"
+
+// ownerAnnotation holds the name prefix of the output which wrote an object,
+// so that outputs sharing a namespace and object labels only delete their own
+// objects
+const ownerAnnotation = "prometheus-puppetdb-sd/name-prefix"
+
+const (
+	maxObjectNameLength  = 253
+	maxServiceNameLength = 63
+)
+
+var (
+	invalidObjectNameCharRegexp  = regexp.MustCompile(`[^a-z0-9.-]+`)
+	invalidServiceNameCharRegexp = regexp.MustCompile(`[^a-z0-9-]+`)
+)
+
+// k8sClientConfig returns the Kubernetes client configuration, and the
+// namespace from the kubeconfig when namespace is empty
+func k8sClientConfig(namespace string) (restConfig *rest.Config, ns string, err error) {
+	kubeconfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		clientcmd.NewDefaultClientConfigLoadingRules(),
+		&clientcmd.ConfigOverrides{},
+	)
+
+	restConfig, err = kubeconfig.ClientConfig()
+	if err != nil {
+		return nil, "", err
+	}
+
+	ns = namespace
+	if ns == "" {
+		ns, _, err = kubeconfig.Namespace()
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to retrieve namespace: %s", err)
+		}
+	}
+	return
+}
+
+// k8sError wraps a Kubernetes API error with its kind, so that missing
+// objects, missing permissions and an unavailable API server are told apart
+func k8sError(action, object string, err error) error {
+	var reason string
+
+	switch {
+	case apierrors.IsNotFound(err):
+		reason = "not found"
+	case apierrors.IsForbidden(err), apierrors.IsUnauthorized(err):
+		reason = "permission denied"
+	case apierrors.IsConflict(err):
+		reason = "conflict"
+	case apierrors.IsInvalid(err), apierrors.IsBadRequest(err), apierrors.IsRequestEntityTooLargeError(err):
+		reason = "rejected"
+	case apierrors.IsServiceUnavailable(err), apierrors.IsServerTimeout(err), apierrors.IsTimeout(err),
+		apierrors.IsTooManyRequests(err), apierrors.IsInternalError(err), utilnet.IsConnectionRefused(err),
+		utilnet.IsConnectionReset(err), utilnet.IsProbableEOF(err):
+		reason = "API server unavailable"
+	default:
+		return fmt.Errorf("failed to %s %s (%s)", action, object, err)
+	}
+
+	return fmt.Errorf("failed to %s %s, %s (%s)", action, object, reason, err)
+}
+
+// objectNames returns the Kubernetes object name of each job. Job names are
+// sanitized to follow Kubernetes object name rules, and altered names get a
+// hash suffix when they collide.
+func objectNames(prefix string, scrapeConfigs []*types.ScrapeConfig, sanitize func(string) string, maxLength int) (names map[string]string) {
+	names = make(map[string]string, len(scrapeConfigs))
+	counts := map[string]int{}
+
+	jobNames := make([]string, 0, len(scrapeConfigs))
+	for _, scrapeConfig := range scrapeConfigs {
+		jobNames = append(jobNames, scrapeConfig.JobName)
+	}
+	sort.Strings(jobNames)
+
+	for _, jobName := range jobNames {
+		name := sanitize(prefix + jobName)
+		names[jobName] = name
+		counts[name]++
+	}
+
+	for _, jobName := range jobNames {
+		name := names[jobName]
+		if counts[name] > 1 && name != prefix+jobName {
+			names[jobName] = sanitize(name[:min(len(name), maxLength-9)] + "-" + shortHash(jobName))
+		}
+	}
+	return
+}
+
+// sanitizeObjectName makes a value a valid Kubernetes object name
+func sanitizeObjectName(value string) string {
+	sanitized := invalidObjectNameCharRegexp.ReplaceAllString(strings.ToLower(value), "-")
+	sanitized = strings.Trim(sanitized, "-.")
+
+	if len(sanitized) > maxObjectNameLength {
+		sanitized = strings.Trim(sanitized[:maxObjectNameLength-9], "-.") + "-" + shortHash(value)
+	}
+	if sanitized == "" {
+		sanitized = shortHash(value)
+	}
+	return sanitized
+}
+
+// sanitizeServiceName makes a value a valid Kubernetes Service name, which
+// must be a DNS label starting with a letter
+func sanitizeServiceName(value string) string {
+	sanitized := invalidServiceNameCharRegexp.ReplaceAllString(strings.ToLower(value), "-")
+	sanitized = strings.Trim(sanitized, "-")
+
+	if sanitized == "" || sanitized[0] < 'a' || sanitized[0] > 'z' {
+		sanitized = "s-" + sanitized
+	}
+	if len(sanitized) > maxServiceNameLength || strings.HasSuffix(sanitized, "-") {
+		sanitized = strings.Trim(sanitized[:min(len(sanitized), maxServiceNameLength-9)], "-") + "-" + shortHash(value)
+	}
+	return sanitized
+}
@@ -0,0 +1,182 @@
+package outputs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	testclient "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newApplyClientset returns a fake clientset emulating server-side apply of
+// the objects written by the outputs, which the fake clientset does not
+// support
+func newApplyClientset(objects ...runtime.Object) *testclient.Clientset {
+	client := testclient.NewSimpleClientset(objects...)
+
+	client.PrependReactor("patch", "*", applyReactor(client.Tracker(), func(gvr schema.GroupVersionResource) (runtime.Object, error) {
+		switch gvr.Resource {
+		case "secrets":
+			return &v1.Secret{}, nil
+		case "configmaps":
+			return &v1.ConfigMap{}, nil
+		case "services":
+			return &v1.Service{}, nil
+		case "endpointslices":
+			return &discoveryv1.EndpointSlice{}, nil
+		default:
+			return nil, fmt.Errorf("apply of %s is not emulated", gvr.Resource)
+		}
+	}))
+
+	return client
+}
+
+// newApplyDynamicClient returns a fake dynamic client emulating server-side
+// apply of ScrapeConfig objects
+func newApplyDynamicClient(objects ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(
+		runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			scrapeConfigResource: "ScrapeConfigList",
+		},
+		objects...,
+	)
+
+	client.PrependReactor("patch", "*", applyReactor(client.Tracker(), func(schema.GroupVersionResource) (runtime.Object, error) {
+		return &unstructured.Unstructured{}, nil
+	}))
+
+	return client
+}
+
+// applyReactor emulates server-side apply by a single field manager: the
+// labels, annotations and data keys of the previous apply are replaced by the
+// ones of the new apply while others are kept, and the other fields of the
+// applied object replace the current ones
+func applyReactor(tracker k8stesting.ObjectTracker, newObject func(schema.GroupVersionResource) (runtime.Object, error)) k8stesting.ReactionFunc {
+	owned := map[string]map[string]map[string]struct{}{}
+
+	return func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patchAction := action.(k8stesting.PatchAction)
+		if patchAction.GetPatchType() != types.ApplyPatchType {
+			return false, nil, nil
+		}
+
+		gvr := patchAction.GetResource()
+		namespace, name := patchAction.GetNamespace(), patchAction.GetName()
+
+		object, err := newObject(gvr)
+		if err != nil {
+			return true, nil, err
+		}
+
+		applied := map[string]interface{}{}
+		err = json.Unmarshal(patchAction.GetPatch(), &applied)
+		if err != nil {
+			return true, nil, err
+		}
+
+		current := map[string]interface{}{}
+		existing, err := tracker.Get(gvr, namespace, name)
+		if err == nil {
+			current, err = runtime.DefaultUnstructuredConverter.ToUnstructured(existing)
+			if err != nil {
+				return true, nil, err
+			}
+		} else if !apierrors.IsNotFound(err) {
+			return true, nil, err
+		}
+
+		key := fmt.Sprintf("%s/%s/%s", gvr.Resource, namespace, name)
+		if owned[key] == nil {
+			owned[key] = map[string]map[string]struct{}{}
+		}
+
+		metadata := nestedMap(current, "metadata")
+		metadata["name"] = name
+		metadata["namespace"] = namespace
+
+		appliedMetadata := nestedMap(applied, "metadata")
+
+		for _, field := range []string{"labels", "annotations", "data"} {
+			parent, values := metadata, nestedMap(appliedMetadata, field)
+			if field == "data" {
+				parent, values = current, nestedMap(applied, field)
+			}
+			target := nestedMap(parent, field)
+
+			for name := range owned[key][field] {
+				delete(target, name)
+			}
+			owned[key][field] = map[string]struct{}{}
+
+			for name, value := range values {
+				target[name] = value
+				owned[key][field][name] = struct{}{}
+			}
+
+			if len(target) == 0 {
+				delete(parent, field)
+			}
+		}
+
+		for field, value := range applied {
+			if field != "metadata" && field != "data" {
+				current[field] = value
+			}
+		}
+
+		if u, ok := object.(*unstructured.Unstructured); ok {
+			u.Object = current
+		} else {
+			err = runtime.DefaultUnstructuredConverter.FromUnstructured(current, object)
+			if err != nil {
+				return true, nil, err
+			}
+		}
+
+		if existing == nil {
+			err = tracker.Create(gvr, object, namespace)
+		} else {
+			err = tracker.Update(gvr, object, namespace)
+		}
+		return true, object, err
+	}
+}
+
+// nestedMap returns the map of a field, creating it when missing
+func nestedMap(m map[string]interface{}, field string) map[string]interface{} {
+	nested, ok := m[field].(map[string]interface{})
+	if !ok {
+		nested = map[string]interface{}{}
+		m[field] = nested
+	}
+	return nested
+}
+
+func TestK8sError(t *testing.T) {
+	resource := schema.GroupResource{Resource: "secrets"}
+
+	for expectedError, err := range map[string]error{
+		"failed to apply secret 'out', not found":              apierrors.NewNotFound(resource, "out"),
+		"failed to apply secret 'out', permission denied":      apierrors.NewForbidden(resource, "out", errors.New("denied")),
+		"failed to apply secret 'out', conflict":               apierrors.NewConflict(resource, "out", errors.New("modified")),
+		"failed to apply secret 'out', rejected":               apierrors.NewRequestEntityTooLargeError("too large"),
+		"failed to apply secret 'out', API server unavailable": apierrors.NewServiceUnavailable("unavailable"),
+		"failed to apply secret 'out' (unexpected)":            errors.New("unexpected"),
+	} {
+		assert.ErrorContains(t, k8sError("apply", "secret 'out'", err), expectedError)
+	}
+}
@@ -0,0 +1,208 @@
+package outputs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/types"
+)
+
+const (
+	keyPlaceholder   = "*"
+	maxKeyPartLength = 200
+	maxKeyLength     = 253
+)
+
+var (
+	invalidKeyCharRegexp = regexp.MustCompile(`[^-._a-zA-Z0-9]`)
+	keyRegexp            = regexp.MustCompile(`^[-._a-zA-Z0-9]+$`)
+)
+
+// keyMapping maps static configurations to file names or Kubernetes secret
+// keys rendered from a pattern. Patterns are templates where {{job}} is
+// replaced by the job name, {{label "name"}} by the value of a label and
+// {{shard}} by the shard of the targets, '*' being a shorthand for {{job}}.
+// Values are sanitized so that keys can neither escape the output directory
+// nor break the Kubernetes key character rules.
+type keyMapping struct {
+	template *template.Template
+}
+
+// keyParts stores the values a key was rendered from
+type keyParts struct {
+	values  []string
+	altered bool
+}
+
+func newKeyMapping(pattern string) (m *keyMapping, err error) {
+	if !strings.Contains(pattern, keyPlaceholder) && !strings.Contains(pattern, "{{") {
+		return nil, fmt.Errorf("pattern '%s' has no placeholder", pattern)
+	}
+
+	tmpl, err := template.New("key").Funcs(template.FuncMap{
+		"job":   func() string { return "" },
+		"label": func(string) string { return "" },
+		"shard": func() string { return "" },
+	}).Parse(strings.ReplaceAll(pattern, keyPlaceholder, "{{job}}"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern '%s' (%s)", pattern, err)
+	}
+
+	m = &keyMapping{
+		template: tmpl,
+	}
+
+	key, _, err := m.render("job", map[string]string{}, false)
+	if err == nil {
+		err = validateKey(key)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern '%s' (%s)", pattern, err)
+	}
+	return
+}
+
+// render renders the key of a static configuration. If suffix is true,
+// values altered by the sanitization get a hash suffix.
+func (m *keyMapping) render(jobName string, labels map[string]string, suffix bool) (key string, parts *keyParts, err error) {
+	parts = &keyParts{}
+
+	sanitize := func(value string) string {
+		parts.values = append(parts.values, value)
+
+		sanitized := sanitizeKeyPart(value)
+		if sanitized != value {
+			parts.altered = true
+			if suffix {
+				sanitized += "-" + shortHash(value)
+			}
+		}
+		return sanitized
+	}
+
+	tmpl, err := m.template.Clone()
+	if err != nil {
+		return
+	}
+	tmpl.Funcs(template.FuncMap{
+		"job": func() string {
+			return sanitize(jobName)
+		},
+		"label": func(name string) string {
+			return sanitize(labels[name])
+		},
+		"shard": func() string {
+			return sanitize(labels[types.ShardLabel])
+		},
+	})
+
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, nil)
+	if err != nil {
+		return
+	}
+	return buf.String(), parts, nil
+}
+
+// group groups the static configurations of scrape configurations by key.
+// When keys rendered from different values collide, the values altered by
+// the sanitization get a hash suffix.
+func (m *keyMapping) group(scrapeConfigs []*types.ScrapeConfig) (groups map[string][]*types.StaticConfig, err error) {
+	type entry struct {
+		jobName      string
+		staticConfig *types.StaticConfig
+		key          string
+		parts        *keyParts
+	}
+
+	entries := []*entry{}
+	ids := map[string]map[string]struct{}{}
+
+	for _, scrapeConfig := range scrapeConfigs {
+		for _, staticConfig := range scrapeConfig.StaticConfigs {
+			e := &entry{
+				jobName:      scrapeConfig.JobName,
+				staticConfig: staticConfig,
+			}
+
+			e.key, e.parts, err = m.render(e.jobName, staticConfig.Labels, false)
+			if err != nil {
+				return
+			}
+
+			if ids[e.key] == nil {
+				ids[e.key] = map[string]struct{}{}
+			}
+			ids[e.key][strings.Join(e.parts.values, "\xff")] = struct{}{}
+
+			entries = append(entries, e)
+		}
+	}
+
+	groups = map[string][]*types.StaticConfig{}
+
+	for _, e := range entries {
+		if len(ids[e.key]) > 1 && e.parts.altered {
+			e.key, _, err = m.render(e.jobName, e.staticConfig.Labels, true)
+			if err != nil {
+				return
+			}
+		}
+
+		err = validateKey(e.key)
+		if err != nil {
+			return nil, err
+		}
+
+		groups[e.key] = append(groups[e.key], e.staticConfig)
+	}
+	return
+}
+
+// sortedKeys returns the keys of groups of static configurations, sorted
+func sortedKeys(groups map[string][]*types.StaticConfig) []string {
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sanitizeKeyPart makes a value safe to be used in a file name or a
+// Kubernetes secret key
+func sanitizeKeyPart(value string) string {
+	sanitized := invalidKeyCharRegexp.ReplaceAllString(value, "_")
+
+	// Prevent "." and ".." keys
+	if strings.Trim(sanitized, ".") == "" {
+		sanitized = strings.Repeat("_", len(sanitized)+1)
+	}
+
+	if len(sanitized) > maxKeyPartLength {
+		sanitized = sanitized[:maxKeyPartLength-9] + "-" + shortHash(value)
+	}
+	return sanitized
+}
+
+// validateKey checks that a key is a valid Kubernetes secret key, which also
+// makes it a file name which cannot escape its directory
+func validateKey(key string) error {
+	if len(key) > maxKeyLength {
+		return fmt.Errorf("key '%s' is longer than %d characters", key, maxKeyLength)
+	}
+	if !keyRegexp.MatchString(key) || key == "." || key == ".." {
+		return fmt.Errorf("key '%s' is not a valid file name or secret key", key)
+	}
+	return nil
+}
+
+// shortHash returns a short hash of a value
+func shortHash(value string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(value)))[:8]
+}
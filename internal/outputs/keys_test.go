@@ -0,0 +1,93 @@
+package outputs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/types"
+)
+
+func TestSanitizeKeyPart(t *testing.T) {
+	for value, expected := range map[string]string{
+		"node-exporter":    "node-exporter",
+		"node_exporter.v2": "node_exporter.v2",
+		"../../etc/passwd": ".._.._etc_passwd",
+		"team 1/exporter":  "team_1_exporter",
+		"..":               "___",
+		".":                "__",
+		"":                 "_",
+		"exporter:9100":    "exporter_9100",
+		"exporter\x00.yml": "exporter_.yml",
+		"café-exporter":    "caf_-exporter",
+	} {
+		assert.Equal(t, expected, sanitizeKeyPart(value), value)
+	}
+}
+
+func TestKeyMappingGroupJobs(t *testing.T) {
+	m, err := newKeyMapping("*.yml")
+	if err != nil {
+		assert.FailNow(t, "Failed to create key mapping", err.Error())
+	}
+
+	staticConfigs := []*types.StaticConfig{{}, {}, {}, {}, {}}
+
+	groups, err := m.group([]*types.ScrapeConfig{
+		{JobName: "node-exporter", StaticConfigs: staticConfigs[0:2]},
+		{JobName: "team_1", StaticConfigs: staticConfigs[2:3]},
+		{JobName: "team 1", StaticConfigs: staticConfigs[3:4]},
+		{JobName: "team/1", StaticConfigs: staticConfigs[4:5]},
+	})
+	if err != nil {
+		assert.FailNow(t, "Failed to group static configs", err.Error())
+	}
+
+	assert.Equal(t, map[string][]*types.StaticConfig{
+		"node-exporter.yml":                      staticConfigs[0:2],
+		"team_1.yml":                             staticConfigs[2:3],
+		"team_1-" + shortHash("team 1") + ".yml": staticConfigs[3:4],
+		"team_1-" + shortHash("team/1") + ".yml": staticConfigs[4:5],
+	}, groups)
+}
+
+func TestKeyMappingGroupLabels(t *testing.T) {
+	m, err := newKeyMapping(`{{job}}-{{label "team"}}.yml`)
+	if err != nil {
+		assert.FailNow(t, "Failed to create key mapping", err.Error())
+	}
+
+	staticConfigs := []*types.StaticConfig{
+		{Labels: map[string]string{"team": "team-1"}},
+		{Labels: map[string]string{"team": "team-2"}},
+		{Labels: map[string]string{"team": "team-1"}},
+		{Labels: map[string]string{}},
+	}
+
+	groups, err := m.group([]*types.ScrapeConfig{
+		{JobName: "node-exporter", StaticConfigs: staticConfigs},
+	})
+	if err != nil {
+		assert.FailNow(t, "Failed to group static configs", err.Error())
+	}
+
+	assert.Equal(t, map[string][]*types.StaticConfig{
+		"node-exporter-team-1.yml": {staticConfigs[0], staticConfigs[2]},
+		"node-exporter-team-2.yml": {staticConfigs[1]},
+		"node-exporter-_.yml":      {staticConfigs[3]},
+	}, groups)
+}
+
+func TestNewKeyMappingErrors(t *testing.T) {
+	for _, pattern := range []string{
+		"",
+		"puppetdb-sd.yml",
+		"../*.yml",
+		"sub/*.yml",
+		"{{job}.yml",
+		`{{label "team"}}/{{job}}.yml`,
+	} {
+		_, err := newKeyMapping(pattern)
+		assert.Error(t, err, pattern)
+	}
+}
@@ -13,8 +13,25 @@ type Output interface {
 	WriteOutput(ctx context.Context, scrapeConfigs []*types.ScrapeConfig) (err error)
 }
 
+// Notifier is implemented by outputs watching their configuration, which
+// need to be written again as soon as it changes
+type Notifier interface {
+	Changes() <-chan struct{}
+}
+
 // Setup returns an output type
 func Setup(cfg *config.OutputConfig) (Output, error) {
+	if cfg.Format == config.PrometheusConfig && cfg.Encoding == config.JSONEncoding {
+		return nil, fmt.Errorf("the prometheus-config format is only supported with the yaml encoding")
+	}
+
+	extraConfig := cfg.ExtraConfigFile != "" ||
+		(cfg.Method == config.K8sSecret && cfg.K8sSecret.ExtraConfigSecretName != "") ||
+		(cfg.Method == config.K8sConfigMap && cfg.K8sConfigMap.ExtraConfigConfigMapName != "")
+	if cfg.Format == config.PrometheusConfig && extraConfig {
+		return nil, fmt.Errorf("extra config is not supported with the prometheus-config format, add it to the base config instead")
+	}
+
 	switch cfg.Method {
 	case config.Stdout:
 		return setupStdoutOutput(cfg)
@@ -22,6 +39,12 @@ func Setup(cfg *config.OutputConfig) (Output, error) {
 		return setupFileOutput(cfg)
 	case config.K8sSecret:
 		return setupK8sSecretOutput(cfg)
+	case config.K8sConfigMap:
+		return setupK8sConfigMapOutput(cfg)
+	case config.K8sScrapeConfig:
+		return setupK8sScrapeConfigOutput(cfg)
+	case config.K8sService:
+		return setupK8sServiceOutput(cfg)
 	default:
 		return nil, fmt.Errorf("unknown output method: '%s'", cfg.Method)
 	}
@@ -0,0 +1,147 @@
+package outputs
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/config"
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/types"
+)
+
+// renderer turns scrape configurations into named documents, such as files
+// or secret keys, according to an output format and encoding
+type renderer struct {
+	format          config.OutputFormat
+	encoding        config.OutputEncoding
+	name            string
+	pattern         string
+	baseConfig      []byte
+	baseConfigFile  string
+	extraConfig     []byte
+	extraConfigFile string
+}
+
+// validate checks the document name pattern of a renderer
+func (r *renderer) validate() (err error) {
+	if r.format == config.StaticConfigs {
+		_, err = newKeyMapping(r.pattern)
+	}
+	return
+}
+
+// render returns the documents of scrape configurations, by name
+func (r *renderer) render(scrapeConfigs []*types.ScrapeConfig) (documents map[string][]byte, err error) {
+	documents = map[string][]byte{}
+
+	var extra []interface{}
+	if r.format != config.PrometheusConfig {
+		var content []byte
+		content, err = loadDocument(r.extraConfig, r.extraConfigFile, "extra config")
+		if err != nil {
+			return nil, err
+		}
+
+		extra, err = parseExtraConfig(r.format, content)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var c []byte
+
+	switch r.format {
+	case config.ScrapeConfigs:
+		for name, shardConfigs := range splitShards(r.name, scrapeConfigs) {
+			var merged []interface{}
+			merged, err = appendExtraScrapeConfigs(shardConfigs, extra)
+			if err != nil {
+				return nil, err
+			}
+
+			c, err = encode(r.encoding, merged)
+			if err != nil {
+				return nil, err
+			}
+
+			documents[name] = c
+		}
+	case config.StaticConfigs:
+		var m *keyMapping
+		m, err = newKeyMapping(r.pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern (%s)", err)
+		}
+
+		scrapeConfigs, err = mergeExtraJobs(scrapeConfigs, extra)
+		if err != nil {
+			return nil, err
+		}
+
+		var groups map[string][]*types.StaticConfig
+		groups, err = m.group(scrapeConfigs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to map static configs to document names (%s)", err)
+		}
+
+		for name, staticConfigs := range groups {
+			c, err = encode(r.encoding, staticConfigs)
+			if err != nil {
+				return nil, err
+			}
+
+			documents[name] = c
+		}
+	case config.MergedStaticConfigs:
+		for name, shardConfigs := range splitShards(r.name, scrapeConfigs) {
+			c, err = encode(r.encoding, appendExtraStaticConfigs(shardConfigs, extra))
+			if err != nil {
+				return nil, err
+			}
+
+			documents[name] = c
+		}
+	case config.PrometheusConfig:
+		var base []byte
+		base, err = loadDocument(r.baseConfig, r.baseConfigFile, "base config")
+		if err != nil {
+			return nil, err
+		}
+
+		for name, shardConfigs := range splitShards(r.name, scrapeConfigs) {
+			c, err = mergeBaseConfig(base, shardConfigs)
+			if err != nil {
+				return nil, err
+			}
+
+			documents[name] = c
+		}
+	default:
+		return nil, fmt.Errorf("unexpected output format '%s'", r.format)
+	}
+	return
+}
+
+// loadDocument returns content, or else the content of filename. Files are
+// read on every rendering, so that their changes are taken into account.
+func loadDocument(content []byte, filename, description string) ([]byte, error) {
+	if content != nil || filename == "" {
+		return content, nil
+	}
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s file (%s)", description, err)
+	}
+	return content, nil
+}
+
+// sortedDocumentNames returns the names of documents in order
+func sortedDocumentNames(documents map[string][]byte) []string {
+	names := make([]string, 0, len(documents))
+	for name := range documents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,66 @@
+package outputs
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/config"
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/types"
+)
+
+func TestRendererRender(t *testing.T) {
+	for _, format := range []config.OutputFormat{config.ScrapeConfigs, config.StaticConfigs, config.MergedStaticConfigs} {
+		for _, encoding := range []config.OutputEncoding{config.YAMLEncoding, config.JSONEncoding} {
+			r := &renderer{
+				format:   format,
+				encoding: encoding,
+				name:     "puppetdb-sd",
+				pattern:  "{{job}}",
+			}
+
+			documents, err := r.render(scrapeConfigs[0])
+			if !assert.NoError(t, err, "%s %s", format, encoding) {
+				continue
+			}
+
+			expectedNames := []string{"puppetdb-sd"}
+			if format == config.StaticConfigs {
+				expectedNames = []string{"apache-exporter", "node-exporter"}
+			}
+			assert.Equal(t, expectedNames, sortedDocumentNames(documents), "%s %s", format, encoding)
+
+			if encoding == config.JSONEncoding {
+				for name, content := range documents {
+					assert.True(t, json.Valid(content), "%s %s %s", format, encoding, name)
+				}
+			}
+		}
+	}
+}
+
+func TestRendererRenderMergedEmptyJob(t *testing.T) {
+	r := &renderer{
+		format:   config.MergedStaticConfigs,
+		encoding: config.YAMLEncoding,
+		name:     "puppetdb-sd.yml",
+	}
+
+	documents, err := r.render([]*types.ScrapeConfig{
+		{JobName: "empty"},
+		scrapeConfigs[1][0],
+	})
+	if err != nil {
+		assert.FailNow(t, "Failed to render documents", err.Error())
+	}
+
+	assert.Equal(t, expectedOutputs[1][config.MergedStaticConfigs].(string)[1:], string(documents["puppetdb-sd.yml"]))
+}
+
+func TestRendererRenderUnknownFormat(t *testing.T) {
+	r := &renderer{format: "unknown"}
+
+	_, err := r.render(scrapeConfigs[0])
+	assert.EqualError(t, err, "unexpected output format 'unknown'")
+}
@@ -0,0 +1,41 @@
+package outputs
+
+import (
+	"strings"
+
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/types"
+)
+
+const shardPlaceholder = "{{shard}}"
+
+// splitShards splits scrape configurations by shard when a file name or a
+// secret key contains the {{shard}} placeholder. It returns the scrape
+// configurations of each rendered key.
+func splitShards(key string, scrapeConfigs []*types.ScrapeConfig) (shards map[string][]*types.ScrapeConfig) {
+	if !strings.Contains(key, shardPlaceholder) {
+		return map[string][]*types.ScrapeConfig{key: scrapeConfigs}
+	}
+
+	shards = map[string][]*types.ScrapeConfig{}
+
+	for _, scrapeConfig := range scrapeConfigs {
+		shardConfigs := map[string]*types.ScrapeConfig{}
+
+		for _, staticConfig := range scrapeConfig.StaticConfigs {
+			shardKey := strings.ReplaceAll(key, shardPlaceholder, sanitizeKeyPart(staticConfig.Labels[types.ShardLabel]))
+
+			shardConfig, ok := shardConfigs[shardKey]
+			if !ok {
+				c := *scrapeConfig
+				c.StaticConfigs = nil
+				shardConfig = &c
+
+				shardConfigs[shardKey] = shardConfig
+				shards[shardKey] = append(shards[shardKey], shardConfig)
+			}
+
+			shardConfig.StaticConfigs = append(shardConfig.StaticConfigs, staticConfig)
+		}
+	}
+	return
+}
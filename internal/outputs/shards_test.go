@@ -0,0 +1,40 @@
+package outputs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/types"
+)
+
+func TestSplitShards(t *testing.T) {
+	shard0 := &types.StaticConfig{
+		Targets: []string{"server-1.example.com:9100"},
+		Labels:  map[string]string{types.ShardLabel: "0", types.ShardsLabel: "2"},
+	}
+	shard1 := &types.StaticConfig{
+		Targets: []string{"server-2.example.com:9100"},
+		Labels:  map[string]string{types.ShardLabel: "1", types.ShardsLabel: "2"},
+	}
+
+	scrapeConfigs := []*types.ScrapeConfig{
+		{
+			JobName:       "node-exporter",
+			StaticConfigs: []*types.StaticConfig{shard0, shard1},
+		},
+	}
+
+	assert.Equal(t, map[string][]*types.ScrapeConfig{
+		"puppetdb-sd.yml": scrapeConfigs,
+	}, splitShards("puppetdb-sd.yml", scrapeConfigs))
+
+	assert.Equal(t, map[string][]*types.ScrapeConfig{
+		"puppetdb-sd-0.yml": {
+			{JobName: "node-exporter", StaticConfigs: []*types.StaticConfig{shard0}},
+		},
+		"puppetdb-sd-1.yml": {
+			{JobName: "node-exporter", StaticConfigs: []*types.StaticConfig{shard1}},
+		},
+	}, splitShards("puppetdb-sd-{{shard}}.yml", scrapeConfigs))
+}
@@ -2,50 +2,75 @@ package outputs
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 
 	"github.com/camptocamp/prometheus-puppetdb-sd/internal/config"
 	"github.com/camptocamp/prometheus-puppetdb-sd/internal/types"
-	yaml "gopkg.in/yaml.v1"
 )
 
 // StdoutOutput stores values needed to print output to stdout
 type StdoutOutput struct {
-	format config.OutputFormat
+	format          config.OutputFormat
+	encoding        config.OutputEncoding
+	baseConfigFile  string
+	extraConfigFile string
 }
 
 func setupStdoutOutput(cfg *config.OutputConfig) (*StdoutOutput, error) {
 	return &StdoutOutput{
-		format: cfg.Format,
+		format:          cfg.Format,
+		encoding:        cfg.Encoding,
+		baseConfigFile:  cfg.BaseConfigFile,
+		extraConfigFile: cfg.ExtraConfigFile,
 	}, nil
 }
 
-// WriteOutput writes Prometheus configuration to stdout
+// WriteOutput writes Prometheus configuration to stdout. When several
+// documents are rendered, YAML documents are preceded by a separator holding
+// their name, and JSON documents are printed as a single object by name.
 func (o *StdoutOutput) WriteOutput(ctx context.Context, scrapeConfigs []*types.ScrapeConfig) (err error) {
-	var c []byte
+	documents, err := o.renderer().render(scrapeConfigs)
+	if err != nil {
+		return
+	}
+
+	if len(documents) > 1 && o.encoding == config.JSONEncoding {
+		objects := make(map[string]json.RawMessage, len(documents))
+		for name, content := range documents {
+			objects[name] = content
+		}
 
-	switch o.format {
-	case config.ScrapeConfigs:
-		c, err = yaml.Marshal(scrapeConfigs)
+		var c []byte
+		c, err = encode(o.encoding, objects)
 		if err != nil {
 			return
 		}
 
 		fmt.Printf("%s", string(c))
-	case config.MergedStaticConfigs:
-		for _, scrapeConfig := range scrapeConfigs {
-			c, err = yaml.Marshal(scrapeConfig.StaticConfigs)
-			if err != nil {
-				return
-			}
-
-			fmt.Printf("%s", string(c))
-		}
-	default:
-		err = fmt.Errorf("unexpected output format '%s'", o.format)
 
 		return
 	}
 
+	for _, name := range sortedDocumentNames(documents) {
+		if len(documents) > 1 {
+			fmt.Printf("--- # %s\n", name)
+		}
+
+		fmt.Printf("%s", string(documents[name]))
+	}
+
 	return
 }
+
+// renderer returns the renderer of the printed documents, static
+// configurations being named after their job
+func (o *StdoutOutput) renderer() *renderer {
+	return &renderer{
+		format:          o.format,
+		encoding:        o.encoding,
+		pattern:         keyPlaceholder,
+		baseConfigFile:  o.baseConfigFile,
+		extraConfigFile: o.extraConfigFile,
+	}
+}
@@ -3,8 +3,10 @@ package outputs
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io"
 	"os"
+	"sort"
 	"strings"
 	"testing"
 
@@ -47,7 +49,10 @@ func (o *StdoutOutput) testStdoutWriteOutput(t *testing.T) {
 		}
 
 		output := buf.String()
-		expectedOutput := expectedOutputs[i][o.format].(string)
+		expectedOutput, ok := expectedOutputs[i][o.format].(string)
+		if !ok {
+			expectedOutput = expectedStdoutDocuments(expectedOutputs[i][o.format].(map[string]string))
+		}
 
 		assert.Equal(t, strings.TrimSpace(expectedOutput), strings.TrimSpace(output))
 	}
@@ -63,6 +68,32 @@ func TestStdoutWriteOutputScrapeConfigsSuccess(t *testing.T) {
 	o.testStdoutWriteOutput(t)
 }
 
+// expectedStdoutDocuments returns the expected output of several documents,
+// printed in order after their name
+func expectedStdoutDocuments(documents map[string]string) (output string) {
+	names := make([]string, 0, len(documents))
+	for name := range documents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if len(documents) > 1 {
+			output += fmt.Sprintf("--- # %s\n", name)
+		}
+		output += strings.TrimSpace(documents[name]) + "\n"
+	}
+	return
+}
+
+func TestStdoutWriteOutputStaticConfigsSuccess(t *testing.T) {
+	o := StdoutOutput{
+		format: config.StaticConfigs,
+	}
+
+	o.testStdoutWriteOutput(t)
+}
+
 func TestStdoutWriteOutputMergedStaticConfigsSuccess(t *testing.T) {
 	o := StdoutOutput{
 		format: config.MergedStaticConfigs,
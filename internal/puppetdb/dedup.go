@@ -0,0 +1,151 @@
+package puppetdb
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/config"
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/metrics"
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/types"
+)
+
+// targetOwner references the resource a target is kept from
+type targetOwner struct {
+	certname  string
+	labels    map[string]string
+	timestamp time.Time
+}
+
+// deduplicateTargets resolves the targets exported several times for a job,
+// and returns the certnames exporting each duplicate target
+func deduplicateTargets(scrapeConfig *types.ScrapeConfig, certnames map[*types.StaticConfig]string, timestamps map[string]time.Time, strategy config.DuplicateTargetStrategy) (duplicates map[string][]string) {
+	owners := map[string]*targetOwner{}
+	endpoints := []string{}
+	targets := map[string]string{}
+
+	for _, staticConfig := range scrapeConfig.StaticConfigs {
+		certname := certnames[staticConfig]
+
+		for _, target := range staticConfig.Targets {
+			endpoint := endpointKey(target, staticConfig.Labels)
+
+			owner := &targetOwner{
+				certname:  certname,
+				labels:    staticConfig.Labels,
+				timestamp: timestamps[certname],
+			}
+
+			current, ok := owners[endpoint]
+			if !ok {
+				owners[endpoint] = owner
+				endpoints = append(endpoints, endpoint)
+				targets[endpoint] = target
+				continue
+			}
+
+			if duplicates == nil {
+				duplicates = map[string][]string{}
+			}
+			if len(duplicates[endpoint]) == 0 {
+				duplicates[endpoint] = append(duplicates[endpoint], current.certname)
+			}
+			duplicates[endpoint] = append(duplicates[endpoint], certname)
+
+			switch strategy {
+			case config.FirstDuplicateTarget:
+			case config.NewestDuplicateTarget:
+				if owner.timestamp.After(current.timestamp) {
+					owners[endpoint] = owner
+				}
+			default:
+				labels := make(map[string]string, len(current.labels))
+				for name, value := range owner.labels {
+					labels[name] = value
+				}
+				for name, value := range current.labels {
+					labels[name] = value
+				}
+				current.labels = labels
+			}
+		}
+	}
+
+	if duplicates == nil {
+		return
+	}
+
+	// Group targets back by labels
+	staticConfigs := []*types.StaticConfig{}
+	groups := map[string]*types.StaticConfig{}
+
+	for _, endpoint := range endpoints {
+		owner := owners[endpoint]
+		target := targets[endpoint]
+
+		key := types.LabelsKey(owner.labels)
+		if group, ok := groups[key]; ok {
+			group.Targets = append(group.Targets, target)
+			continue
+		}
+
+		group := &types.StaticConfig{
+			Targets: []string{target},
+			Labels:  owner.labels,
+		}
+		groups[key] = group
+		staticConfigs = append(staticConfigs, group)
+	}
+
+	scrapeConfig.StaticConfigs = staticConfigs
+	return
+}
+
+// endpointKey returns the target with the scheme, metrics path and parameters
+// set by its labels, formatted like an URL, since targets differing by these
+// labels scrape different endpoints
+func endpointKey(target string, labels map[string]string) string {
+	params := url.Values{}
+	for name, value := range labels {
+		if param, ok := strings.CutPrefix(name, "__param_"); ok {
+			params.Set(param, value)
+		}
+	}
+
+	scheme, hasScheme := labels["__scheme__"]
+	path, hasPath := labels["__metrics_path__"]
+	if !hasScheme && !hasPath && len(params) == 0 {
+		return target
+	}
+
+	key := target + path
+	if hasScheme {
+		key = scheme + "://" + key
+	}
+	if len(params) > 0 {
+		key += "?" + params.Encode()
+	}
+	return key
+}
+
+// reportDuplicateTargets logs and exposes the duplicate targets of a job
+func reportDuplicateTargets(jobName string, duplicates map[string][]string) {
+	targets := make([]string, 0, len(duplicates))
+	for target := range duplicates {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+
+	for _, target := range targets {
+		certnames := duplicates[target]
+
+		log.Warnf("Target '%s' of job '%s' is exported several times by certnames %s", target, jobName, strings.Join(certnames, ", "))
+
+		for _, certname := range certnames {
+			metrics.DuplicateTargets.WithLabelValues(jobName, target, certname).Set(1)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package puppetdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/config"
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/types"
+)
+
+func testDuplicateTargets() (*types.ScrapeConfig, map[*types.StaticConfig]string) {
+	staticConfigs := []*types.StaticConfig{
+		{
+			Targets: []string{"server-1.example.com:9100", "vip.example.com:9100"},
+			Labels: map[string]string{
+				"certname": "server-1.example.com",
+				"team":     "team-1",
+			},
+		},
+		{
+			Targets: []string{"server-2.example.com:9100", "vip.example.com:9100"},
+			Labels: map[string]string{
+				"certname":    "server-2.example.com",
+				"team":        "team-2",
+				"environment": "production",
+			},
+		},
+	}
+
+	return &types.ScrapeConfig{
+		JobName:       "node-exporter",
+		StaticConfigs: staticConfigs,
+	}, map[*types.StaticConfig]string{
+		staticConfigs[0]: "server-1.example.com",
+		staticConfigs[1]: "server-2.example.com",
+	}
+}
+
+func TestDeduplicateTargets(t *testing.T) {
+	timestamps := map[string]time.Time{
+		"server-1.example.com": time.Date(2023, 10, 26, 10, 0, 0, 0, time.UTC),
+		"server-2.example.com": time.Date(2023, 10, 26, 11, 0, 0, 0, time.UTC),
+	}
+
+	server1 := map[string]string{
+		"certname": "server-1.example.com",
+		"team":     "team-1",
+	}
+	server2 := map[string]string{
+		"certname":    "server-2.example.com",
+		"team":        "team-2",
+		"environment": "production",
+	}
+
+	expectedStaticConfigs := map[config.DuplicateTargetStrategy][]*types.StaticConfig{
+		config.FirstDuplicateTarget: {
+			{Targets: []string{"server-1.example.com:9100", "vip.example.com:9100"}, Labels: server1},
+			{Targets: []string{"server-2.example.com:9100"}, Labels: server2},
+		},
+		config.NewestDuplicateTarget: {
+			{Targets: []string{"server-1.example.com:9100"}, Labels: server1},
+			{Targets: []string{"vip.example.com:9100", "server-2.example.com:9100"}, Labels: server2},
+		},
+		config.MergeDuplicateTargets: {
+			{Targets: []string{"server-1.example.com:9100"}, Labels: server1},
+			{Targets: []string{"vip.example.com:9100"}, Labels: map[string]string{
+				"certname":    "server-1.example.com",
+				"team":        "team-1",
+				"environment": "production",
+			}},
+			{Targets: []string{"server-2.example.com:9100"}, Labels: server2},
+		},
+	}
+
+	for strategy, expected := range expectedStaticConfigs {
+		scrapeConfig, certnames := testDuplicateTargets()
+
+		duplicates := deduplicateTargets(scrapeConfig, certnames, timestamps, strategy)
+
+		assert.Equal(t, map[string][]string{
+			"vip.example.com:9100": {"server-1.example.com", "server-2.example.com"},
+		}, duplicates, strategy)
+		assert.Equal(t, expected, scrapeConfig.StaticConfigs, strategy)
+	}
+}
+
+func TestDeduplicateTargetsWithoutDuplicates(t *testing.T) {
+	scrapeConfig, certnames := testDuplicateTargets()
+	scrapeConfig.StaticConfigs[1].Targets = []string{"server-2.example.com:9100"}
+
+	staticConfigs := scrapeConfig.StaticConfigs
+
+	duplicates := deduplicateTargets(scrapeConfig, certnames, nil, config.MergeDuplicateTargets)
+
+	assert.Nil(t, duplicates)
+	assert.Equal(t, staticConfigs, scrapeConfig.StaticConfigs)
+}
+
+func TestDeduplicateTargetsEndpoints(t *testing.T) {
+	targetGroups, rejected := normalizeTargets([]string{
+		"http://server-1.example.com:9100/metrics",
+		"http://server-1.example.com:9100/other",
+		"http://server-1.example.com:9100/other?module=a",
+		"http://server-1.example.com:9100/other?module=b",
+		"http://server-1.example.com:9100/other?module=b",
+	}, 0)
+	assert.Empty(t, rejected)
+
+	certnames := map[*types.StaticConfig]string{}
+	for _, staticConfig := range targetGroups {
+		certnames[staticConfig] = "server-1.example.com"
+	}
+
+	scrapeConfig := &types.ScrapeConfig{
+		JobName:       "node-exporter",
+		StaticConfigs: targetGroups,
+	}
+
+	duplicates := deduplicateTargets(scrapeConfig, certnames, nil, config.MergeDuplicateTargets)
+
+	assert.Equal(t, map[string][]string{
+		"http://server-1.example.com:9100/other?module=b": {"server-1.example.com", "server-1.example.com"},
+	}, duplicates)
+	assert.Len(t, scrapeConfig.StaticConfigs, 4)
+}
+
+func TestEndpointKey(t *testing.T) {
+	assert.Equal(t, "server-1.example.com:9100", endpointKey("server-1.example.com:9100", map[string]string{"team": "team-1"}))
+	assert.Equal(t, "https://server-1.example.com:9100/probe?module=http_2xx&target=a", endpointKey("server-1.example.com:9100", map[string]string{
+		"__scheme__":       "https",
+		"__metrics_path__": "/probe",
+		"__param_target":   "a",
+		"__param_module":   "http_2xx",
+	}))
+	assert.Equal(t, "server-1.example.com:9100/metrics", endpointKey("server-1.example.com:9100", map[string]string{"__metrics_path__": "/metrics"}))
+}
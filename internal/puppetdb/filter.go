@@ -0,0 +1,21 @@
+package puppetdb
+
+import (
+	log "github.com/sirupsen/logrus"
+
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/config"
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/metrics"
+)
+
+// reportFilteredTargets logs and exposes the number of targets discarded by
+// each kind of filter
+func reportFilteredTargets(filtered map[string]int) {
+	for _, reason := range []string{config.JobFilter, config.CertnameFilter, config.LabelFilter} {
+		count := filtered[reason]
+		if count > 0 {
+			log.Infof("Filtered out %d targets by %s filters", count, reason)
+		}
+
+		metrics.FilteredTargets.WithLabelValues(reason).Set(float64(count))
+	}
+}
@@ -0,0 +1,194 @@
+package puppetdb
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/config"
+)
+
+const (
+	invalidLabelName  = "invalid label name"
+	invalidLabelValue = "invalid label value"
+	reservedLabel     = "reserved label name"
+	labelCollision    = "label colliding with a service discovery label"
+)
+
+var (
+	labelNameRegexp        = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	invalidLabelCharRegexp = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+)
+
+// labelReport lists the certnames of the resources having labels which
+// failed validation, per problem
+type labelReport map[string]map[string]struct{}
+
+func (r labelReport) add(problem string, policy config.LabelPolicy, certname string) {
+	key := fmt.Sprintf("%s (%s)", problem, policy)
+	if r[key] == nil {
+		r[key] = map[string]struct{}{}
+	}
+	r[key][certname] = struct{}{}
+}
+
+func (r labelReport) log() {
+	keys := make([]string, 0, len(r))
+	for key := range r {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		certnames := make([]string, 0, len(r[key]))
+		for certname := range r[key] {
+			certnames = append(certnames, certname)
+		}
+		sort.Strings(certnames)
+
+		log.Warnf("Resources with %s: %s", key, strings.Join(certnames, ", "))
+	}
+}
+
+// validateLabels applies the label policies to the labels of a resource. It
+// returns the validated labels, or an error if the resource must be
+// rejected.
+func validateLabels(certname string, labels map[string]string, reservedNames []string, cfg *config.PrometheusSDConfig, report labelReport) (validated map[string]string, err error) {
+	validated = make(map[string]string, len(labels))
+
+	namePolicy := labelPolicy(cfg.InvalidLabelNamePolicy, config.RewriteLabel)
+	valuePolicy := labelPolicy(cfg.InvalidLabelValuePolicy, config.RewriteLabel)
+	reservedPolicy := labelPolicy(cfg.ReservedLabelPolicy, config.AllowLabel)
+	collisionPolicy := labelPolicy(cfg.LabelCollisionPolicy, config.DropLabel)
+
+	// Sort names so that rewritten labels never override valid ones
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		iValid, jValid := labelNameRegexp.MatchString(names[i]), labelNameRegexp.MatchString(names[j])
+		if iValid != jValid {
+			return iValid
+		}
+		return names[i] < names[j]
+	})
+
+	for _, name := range names {
+		value := labels[name]
+
+		if !labelNameRegexp.MatchString(name) {
+			report.add(invalidLabelName, namePolicy, certname)
+
+			switch namePolicy {
+			case config.RejectLabel:
+				return nil, fmt.Errorf("invalid label name '%s'", name)
+			case config.DropLabel:
+				continue
+			default:
+				name = sanitizeLabelName(name)
+				if name == "" {
+					continue
+				}
+			}
+		}
+
+		if strings.HasPrefix(name, "__") && reservedPolicy != config.AllowLabel {
+			report.add(reservedLabel, reservedPolicy, certname)
+
+			switch reservedPolicy {
+			case config.RejectLabel:
+				return nil, fmt.Errorf("reserved label name '%s'", name)
+			case config.DropLabel:
+				continue
+			default:
+				name = "exported_" + name
+			}
+		}
+
+		for _, reservedName := range reservedNames {
+			if name != reservedName {
+				continue
+			}
+
+			report.add(labelCollision, collisionPolicy, certname)
+
+			switch collisionPolicy {
+			case config.RejectLabel:
+				return nil, fmt.Errorf("label '%s' collides with a service discovery label", name)
+			case config.RewriteLabel:
+				name = "exported_" + name
+			default:
+				name = ""
+			}
+		}
+		if name == "" {
+			continue
+		}
+
+		if !utf8.ValidString(value) {
+			report.add(invalidLabelValue, valuePolicy, certname)
+
+			switch valuePolicy {
+			case config.RejectLabel:
+				return nil, fmt.Errorf("invalid value of label '%s'", name)
+			case config.DropLabel:
+				continue
+			default:
+				value = strings.ToValidUTF8(value, string(utf8.RuneError))
+			}
+		}
+
+		if _, ok := validated[name]; ok {
+			continue
+		}
+		validated[name] = value
+	}
+	return
+}
+
+// labelPolicy returns the configured label policy, or its default value
+func labelPolicy(policy, defaultPolicy config.LabelPolicy) config.LabelPolicy {
+	if policy == "" {
+		return defaultPolicy
+	}
+	return policy
+}
+
+// sanitizeLabelName rewrites a label name to a valid Prometheus label name
+func sanitizeLabelName(name string) string {
+	if name == "" {
+		return ""
+	}
+
+	name = invalidLabelCharRegexp.ReplaceAllString(name, "_")
+	if name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// mergeExtraLabels adds the extra labels to the labels of a resource. Labels
+// set in both are taken from the resource, unless precedence is given to
+// extra labels.
+func mergeExtraLabels(labels, extraLabels map[string]string, precedence config.ExtraLabelsPrecedence) map[string]string {
+	if len(extraLabels) == 0 {
+		return labels
+	}
+
+	merged := make(map[string]string, len(labels)+len(extraLabels))
+	for name, value := range labels {
+		merged[name] = value
+	}
+	for name, value := range extraLabels {
+		if _, ok := merged[name]; ok && precedence != config.ExtraLabelsFirst {
+			continue
+		}
+		merged[name] = value
+	}
+	return merged
+}
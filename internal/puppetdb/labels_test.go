@@ -0,0 +1,94 @@
+package puppetdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/config"
+)
+
+var invalidLabels = map[string]string{
+	"team":         "team-1",
+	"team-name":    "Team 1",
+	"1st_label":    "first",
+	"certname":     "server-0.example.com",
+	"__scheme__":   "https",
+	"invalid_utf8": "a\xffb",
+}
+
+func TestValidateLabelsDefaults(t *testing.T) {
+	report := labelReport{}
+
+	labels, err := validateLabels("server-1.example.com", invalidLabels, []string{"certname"}, &config.PrometheusSDConfig{}, report)
+	if err != nil {
+		assert.FailNow(t, "Failed to validate labels", err.Error())
+	}
+
+	assert.Equal(t, map[string]string{
+		"team":         "team-1",
+		"team_name":    "Team 1",
+		"_1st_label":   "first",
+		"__scheme__":   "https",
+		"invalid_utf8": "a�b",
+	}, labels)
+	assert.Equal(t, labelReport{
+		"invalid label name (rewrite)":                          {"server-1.example.com": {}},
+		"invalid label value (rewrite)":                         {"server-1.example.com": {}},
+		"label colliding with a service discovery label (drop)": {"server-1.example.com": {}},
+	}, report)
+}
+
+func TestValidateLabelsPolicies(t *testing.T) {
+	cfg := &config.PrometheusSDConfig{
+		InvalidLabelNamePolicy:  config.DropLabel,
+		InvalidLabelValuePolicy: config.DropLabel,
+		ReservedLabelPolicy:     config.RewriteLabel,
+		LabelCollisionPolicy:    config.RewriteLabel,
+	}
+
+	labels, err := validateLabels("server-1.example.com", invalidLabels, []string{"certname"}, cfg, labelReport{})
+	if err != nil {
+		assert.FailNow(t, "Failed to validate labels", err.Error())
+	}
+
+	assert.Equal(t, map[string]string{
+		"team":                "team-1",
+		"exported_certname":   "server-0.example.com",
+		"exported___scheme__": "https",
+	}, labels)
+}
+
+func TestValidateLabelsReject(t *testing.T) {
+	for _, cfg := range []*config.PrometheusSDConfig{
+		{InvalidLabelNamePolicy: config.RejectLabel},
+		{InvalidLabelValuePolicy: config.RejectLabel},
+		{ReservedLabelPolicy: config.RejectLabel},
+		{LabelCollisionPolicy: config.RejectLabel},
+	} {
+		report := labelReport{}
+
+		_, err := validateLabels("server-1.example.com", invalidLabels, []string{"certname"}, cfg, report)
+
+		assert.Error(t, err)
+	}
+}
+
+func TestMergeExtraLabels(t *testing.T) {
+	labels := map[string]string{"team": "team-1", "region": "eu-west"}
+	extraLabels := map[string]string{"region": "eu", "managed_by": "puppet"}
+
+	assert.Equal(t, map[string]string{
+		"team":       "team-1",
+		"region":     "eu-west",
+		"managed_by": "puppet",
+	}, mergeExtraLabels(labels, extraLabels, config.ResourceLabelsFirst))
+
+	assert.Equal(t, map[string]string{
+		"team":       "team-1",
+		"region":     "eu",
+		"managed_by": "puppet",
+	}, mergeExtraLabels(labels, extraLabels, config.ExtraLabelsFirst))
+
+	assert.Equal(t, map[string]string{"team": "team-1", "region": "eu-west"}, labels)
+}
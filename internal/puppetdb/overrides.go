@@ -0,0 +1,48 @@
+package puppetdb
+
+import (
+	"reflect"
+
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/config"
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/types"
+)
+
+// applyJobOverrides applies the matching job overrides to a scrape
+// configuration, in the order they are declared
+func applyJobOverrides(scrapeConfig *types.ScrapeConfig, jobOverrides []*config.JobOverride) {
+	for _, jobOverride := range jobOverrides {
+		if !jobOverride.Matches(scrapeConfig.JobName) {
+			continue
+		}
+
+		setScrapeSettings(&scrapeConfig.ScrapeSettings, &jobOverride.Default.ScrapeSettings, false)
+		if scrapeConfig.MetricRelabelConfigs == nil {
+			scrapeConfig.MetricRelabelConfigs = jobOverride.Default.MetricRelabelConfigs
+		}
+
+		setScrapeSettings(&scrapeConfig.ScrapeSettings, &jobOverride.Override.ScrapeSettings, true)
+		if jobOverride.Override.MetricRelabelConfigs != nil {
+			scrapeConfig.MetricRelabelConfigs = jobOverride.Override.MetricRelabelConfigs
+		}
+	}
+}
+
+// setScrapeSettings copies the settings set in src to dst. Settings already
+// set in dst are only replaced if overwrite is true.
+func setScrapeSettings(dst, src *types.ScrapeSettings, overwrite bool) {
+	dstValue := reflect.ValueOf(dst).Elem()
+	srcValue := reflect.ValueOf(src).Elem()
+
+	for i := 0; i < srcValue.NumField(); i++ {
+		srcField := srcValue.Field(i)
+		dstField := dstValue.Field(i)
+
+		if srcField.IsZero() {
+			continue
+		}
+
+		if overwrite || dstField.IsZero() {
+			dstField.Set(srcField)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package puppetdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/config"
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/types"
+)
+
+func TestApplyJobOverrides(t *testing.T) {
+	sampleLimit := 10000
+
+	jobOverrides := []*config.JobOverride{
+		{
+			JobName: "node-exporter",
+			Default: types.JobSettings{
+				ScrapeSettings: types.ScrapeSettings{
+					ScrapeInterval: "1m",
+					ScrapeTimeout:  "10s",
+				},
+			},
+			Override: types.JobSettings{
+				ScrapeSettings: types.ScrapeSettings{
+					SampleLimit: &sampleLimit,
+				},
+				MetricRelabelConfigs: []*types.RelabelConfig{
+					{
+						SourceLabels: []string{"__name__"},
+						Regex:        "node_systemd_unit_state",
+						Action:       "drop",
+					},
+				},
+			},
+		},
+		{
+			JobName: "apache-exporter",
+			Override: types.JobSettings{
+				ScrapeSettings: types.ScrapeSettings{
+					ScrapeTimeout: "5s",
+				},
+			},
+		},
+	}
+
+	scrapeConfig := &types.ScrapeConfig{
+		JobName: "node-exporter",
+		ScrapeSettings: types.ScrapeSettings{
+			ScrapeInterval: "30s",
+			Scheme:         "https",
+		},
+	}
+
+	applyJobOverrides(scrapeConfig, jobOverrides)
+
+	assert.Equal(t, &types.ScrapeConfig{
+		JobName: "node-exporter",
+		ScrapeSettings: types.ScrapeSettings{
+			ScrapeInterval: "30s",
+			ScrapeTimeout:  "10s",
+			Scheme:         "https",
+			SampleLimit:    &sampleLimit,
+		},
+		MetricRelabelConfigs: []*types.RelabelConfig{
+			{
+				SourceLabels: []string{"__name__"},
+				Regex:        "node_systemd_unit_state",
+				Action:       "drop",
+			},
+		},
+	}, scrapeConfig)
+}
@@ -0,0 +1,87 @@
+package puppetdb
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/config"
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/types"
+)
+
+// findProbe returns the first probe applying to a resource of a job with a
+// probe module parameter, or nil if its targets are scraped
+func findProbe(jobName, probeModule string, probes []*config.Probe) *config.Probe {
+	for _, probe := range probes {
+		if probe.Matches(jobName, probeModule) {
+			return probe
+		}
+	}
+	return nil
+}
+
+// probeTargets groups the targets of a resource probed with a module. Probed
+// endpoints are kept as written, since they may be URLs, host and port pairs
+// or hosts depending on the module, and the empty ones are rejected.
+func probeTargets(targets []string, module string) (groups []*types.StaticConfig, rejected map[string]error) {
+	group := &types.StaticConfig{
+		Labels: map[string]string{
+			"__param_module": module,
+		},
+	}
+
+	for _, target := range targets {
+		endpoint := strings.TrimSpace(target)
+
+		var err error
+		if endpoint == "" {
+			err = fmt.Errorf("target is empty")
+		} else if strings.ContainsAny(endpoint, " \t\r\n") {
+			err = fmt.Errorf("target contains whitespaces")
+		}
+		if err != nil {
+			if rejected == nil {
+				rejected = map[string]error{}
+			}
+			rejected[target] = err
+			continue
+		}
+
+		group.Targets = append(group.Targets, endpoint)
+	}
+
+	if len(group.Targets) > 0 {
+		groups = []*types.StaticConfig{group}
+	}
+	return
+}
+
+// probeScrapeConfig turns the targets of a scrape configuration into probes
+// of a blackbox exporter. Each probed endpoint is passed in the target
+// parameter and kept as instance label, the prober being scraped instead.
+func probeScrapeConfig(scrapeConfig *types.ScrapeConfig, probe *config.Probe) {
+	if scrapeConfig.MetricsPath == "" {
+		scrapeConfig.MetricsPath = probe.MetricsPath
+	}
+
+	staticConfigs := []*types.StaticConfig{}
+
+	for _, staticConfig := range scrapeConfig.StaticConfigs {
+		for _, target := range staticConfig.Targets {
+			labels := make(map[string]string, len(staticConfig.Labels)+2)
+			for name, value := range staticConfig.Labels {
+				labels[name] = value
+			}
+			labels["__param_target"] = target
+			if _, ok := labels["instance"]; !ok {
+				labels["instance"] = target
+			}
+
+			staticConfigs = append(staticConfigs, &types.StaticConfig{
+				Targets: []string{probe.Prober},
+				Labels:  labels,
+			})
+		}
+	}
+
+	scrapeConfig.StaticConfigs = staticConfigs
+}
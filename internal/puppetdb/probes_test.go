@@ -0,0 +1,150 @@
+package puppetdb
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/config"
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/types"
+)
+
+var fakeProbesResponse = `
+[
+	{
+		"certname": "server-1.example.com",
+		"parameters": {
+			"job_name": "website",
+			"targets": [
+				"https://www.example.com/health"
+			],
+			"labels": {
+				"team": "team-1"
+			}
+		}
+	},
+	{
+		"certname": "server-2.example.com",
+		"parameters": {
+			"job_name": "ssh",
+			"targets": [
+				"server-2.example.com:22"
+			],
+			"probe_module": "ssh_banner"
+		}
+	},
+	{
+		"certname": "server-2.example.com",
+		"parameters": {
+			"job_name": "node-exporter",
+			"targets": [
+				"server-2.example.com:9100"
+			]
+		}
+	}
+]
+`
+
+func TestGetScrapeConfigsProbes(t *testing.T) {
+	expectedResult := []*types.ScrapeConfig{
+		{
+			JobName: "node-exporter",
+			StaticConfigs: []*types.StaticConfig{
+				{
+					Targets: []string{"server-2.example.com:9100"},
+					Labels:  map[string]string{"certname": "server-2.example.com"},
+				},
+			},
+		},
+		{
+			JobName: "ssh",
+			ScrapeSettings: types.ScrapeSettings{
+				MetricsPath: "/probe",
+			},
+			StaticConfigs: []*types.StaticConfig{
+				{
+					Targets: []string{"blackbox.example.com:9115"},
+					Labels: map[string]string{
+						"certname":       "server-2.example.com",
+						"instance":       "server-2.example.com:22",
+						"__param_module": "ssh_banner",
+						"__param_target": "server-2.example.com:22",
+					},
+				},
+			},
+		},
+		{
+			JobName: "website",
+			ScrapeSettings: types.ScrapeSettings{
+				MetricsPath: "/probe",
+			},
+			StaticConfigs: []*types.StaticConfig{
+				{
+					Targets: []string{"blackbox.example.com:9115"},
+					Labels: map[string]string{
+						"certname":       "server-1.example.com",
+						"team":           "team-1",
+						"instance":       "https://www.example.com/health",
+						"__param_module": "http_2xx",
+						"__param_target": "https://www.example.com/health",
+					},
+				},
+			},
+		},
+	}
+
+	// Mock http server
+	ts := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/pdb/query/v4" {
+				w.Header().Add("Content-Type", "application/json")
+				w.Write([]byte(fakeProbesResponse))
+			}
+		}),
+	)
+	defer ts.Close()
+
+	client, err := NewClient(&config.PuppetDBConfig{URL: ts.URL})
+	if err != nil {
+		assert.FailNow(t, "Failed to create PuppetDB client", err.Error())
+	}
+
+	probes := []*config.Probe{
+		{
+			JobName: "website",
+			Prober:  "blackbox.example.com:9115",
+			Module:  "http_2xx",
+		},
+		{
+			Prober: "blackbox.example.com:9115",
+			Module: "tcp_connect",
+		},
+	}
+	for _, probe := range probes {
+		err = probe.Compile()
+		if err != nil {
+			assert.FailNow(t, "Failed to compile probe", err.Error())
+		}
+	}
+
+	result, err := client.GetScrapeConfigs(&config.PrometheusSDConfig{Probes: probes})
+	if err != nil {
+		assert.FailNow(t, "Failed to get Prometheus scrape configurations", err.Error())
+	}
+
+	assert.Equal(t, expectedResult, result)
+}
+
+func TestProbeTargetsRejected(t *testing.T) {
+	groups, rejected := probeTargets([]string{" server-1.example.com ", "", "server 2"}, "icmp")
+
+	assert.Equal(t, []*types.StaticConfig{
+		{
+			Targets: []string{"server-1.example.com"},
+			Labels:  map[string]string{"__param_module": "icmp"},
+		},
+	}, groups)
+	assert.Len(t, rejected, 2)
+}
@@ -9,12 +9,20 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"reflect"
 	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
 
 	"github.com/camptocamp/prometheus-puppetdb-sd/internal/config"
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/metrics"
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/relabel"
 	"github.com/camptocamp/prometheus-puppetdb-sd/internal/types"
 )
 
+const catalogTimestampsQuery = "catalogs[certname, producer_timestamp] {}"
+
 // PuppetDB stores a PuppetDB client
 type PuppetDB struct {
 	client *http.Client
@@ -87,12 +95,44 @@ func (p *PuppetDB) GetScrapeConfigs(cfg *config.PrometheusSDConfig) (scrapeConfi
 	scrapeConfigs = []*types.ScrapeConfig{}
 	scrapeConfigMap := map[string]*types.ScrapeConfig{}
 
+	relabeler, err := relabel.New(cfg.RelabelConfigs)
+	if err != nil {
+		err = fmt.Errorf("failed to setup relabeling: %s", err)
+		return
+	}
+
+	filter, err := config.NewFilter(cfg)
+	if err != nil {
+		err = fmt.Errorf("failed to setup filters: %s", err)
+		return
+	}
+
 	resources, err := p.getResources()
 	if err != nil {
 		err = fmt.Errorf("failed to get resources: %s", err)
 		return
 	}
 
+	types.SortResources(resources)
+
+	report := labelReport{}
+	defer report.log()
+
+	certnames := map[*types.StaticConfig]string{}
+	probes := map[*types.ScrapeConfig]*config.Probe{}
+	filtered := map[string]int{}
+	defer reportFilteredTargets(filtered)
+
+	certnameLabel := cfg.CertnameLabelName()
+
+	reservedLabels := []string{}
+	if certnameLabel != "" {
+		reservedLabels = append(reservedLabels, certnameLabel)
+	}
+	if cfg.Shards > 1 {
+		reservedLabels = append(reservedLabels, types.ShardLabel, types.ShardsLabel)
+	}
+
 	for _, resource := range resources {
 		certname := resource.Certname
 		parameters := resource.Parameters
@@ -105,44 +145,170 @@ func (p *PuppetDB) GetScrapeConfigs(cfg *config.PrometheusSDConfig) (scrapeConfi
 			continue
 		}
 
-		if labels == nil {
-			labels = map[string]string{}
+		labels, err := validateLabels(certname, labels, reservedLabels, cfg, report)
+		if err != nil {
+			log.Warnf("Skipping resource of certname '%s': %s", certname, err)
+			continue
+		}
+
+		if reason := filter.Match(jobName, certname, labels); reason != "" {
+			filtered[reason] += len(targets)
+			continue
+		}
+
+		labels = mergeExtraLabels(labels, cfg.ExtraLabels, cfg.ExtraLabelsPrecedence)
+
+		probe := findProbe(jobName, parameters.ProbeModule, cfg.Probes)
+
+		var targetGroups []*types.StaticConfig
+		var rejected map[string]error
+		if probe != nil {
+			module := parameters.ProbeModule
+			if module == "" {
+				module = probe.Module
+			}
+			targetGroups, rejected = probeTargets(targets, module)
+		} else {
+			targetGroups, rejected = normalizeTargets(targets, defaultPort(jobName, cfg.JobOverrides))
+		}
+		reportRejectedTargets(jobName, certname, rejected)
+		if len(targetGroups) == 0 {
+			continue
 		}
 
 		scrapeConfig, ok := scrapeConfigMap[jobName]
 		if !ok {
 			scrapeConfig = &types.ScrapeConfig{
-				JobName:  jobName,
-				ProxyURL: cfg.ProxyURL,
+				JobName: jobName,
 			}
 			scrapeConfigs = append(scrapeConfigs, scrapeConfig)
 			scrapeConfigMap[jobName] = scrapeConfig
+			probes[scrapeConfig] = probe
+		} else if probes[scrapeConfig] != probe {
+			log.Warnf("Skipping resource of certname '%s': job '%s' mixes probed and scraped targets or several probes", certname, jobName)
+			continue
+		}
+
+		scrapeSettings := scrapeConfig.ScrapeSettings
+		conflicts := mergeScrapeSettings(&scrapeSettings, &parameters.ScrapeSettings)
+		if len(conflicts) > 0 {
+			log.Warnf("Skipping resource of certname '%s': settings %s of job '%s' conflict with other resources", certname, strings.Join(conflicts, ", "), jobName)
+			continue
+		}
+
+		// Settings valid on their own may not be once merged, such as a
+		// timeout and an interval set by different resources
+		err = scrapeSettings.Validate()
+		if err != nil {
+			log.Warnf("Skipping resource of certname '%s': settings of job '%s' are invalid with the ones of other resources (%s)", certname, jobName, err)
+			continue
 		}
+		scrapeConfig.ScrapeSettings = scrapeSettings
 
 		staticConfigs := &scrapeConfig.StaticConfigs
 
-		if scrapeConfig.ProxyURL != "" {
-			if scheme, ok := labels["__scheme__"]; ok {
-				labels["__scheme__"] = "http"
-				labels["__param__scheme"] = scheme
+		for _, staticConfig := range targetGroups {
+			// Labels derived from target addresses take precedence
+			for name, value := range labels {
+				if _, ok := staticConfig.Labels[name]; !ok {
+					staticConfig.Labels[name] = value
+				}
+			}
+
+			if certnameLabel != "" {
+				staticConfig.Labels[certnameLabel] = certname
 			}
+
+			*staticConfigs = append(*staticConfigs, staticConfig)
+			certnames[staticConfig] = certname
+		}
+	}
+
+	var timestamps map[string]time.Time
+	if cfg.DuplicateTargetStrategy == config.NewestDuplicateTarget {
+		timestamps, err = p.getCatalogTimestamps()
+		if err != nil {
+			log.Warnf("Failed to get catalog timestamps, keeping first duplicate targets: %s", err)
+			err = nil
 		}
+	}
+
+	metrics.DuplicateTargets.Reset()
+
+	routedScrapeConfigs := []*types.ScrapeConfig{}
+
+	// Jobs split by proxy routes must not reuse the name of another job
+	jobNames := make(map[string]struct{}, len(scrapeConfigs))
+	for _, scrapeConfig := range scrapeConfigs {
+		jobNames[scrapeConfig.JobName] = struct{}{}
+	}
+
+	for _, scrapeConfig := range scrapeConfigs {
+		duplicates := deduplicateTargets(scrapeConfig, certnames, timestamps, cfg.DuplicateTargetStrategy)
+		reportDuplicateTargets(scrapeConfig.JobName, duplicates)
 
-		labels["certname"] = certname
+		relabeler.Apply(scrapeConfig)
+		shardTargets(scrapeConfig, cfg.Shards)
+		if probe := probes[scrapeConfig]; probe != nil {
+			probeScrapeConfig(scrapeConfig, probe)
+		}
+		applyJobOverrides(scrapeConfig, cfg.JobOverrides)
 
-		staticConfig := &types.StaticConfig{
-			Targets: targets,
-			Labels:  labels,
+		// Overrides may set settings which are invalid with the ones of the
+		// resources, such as a timeout greater than their interval
+		err = scrapeConfig.ScrapeSettings.Validate()
+		if err != nil {
+			log.Warnf("Skipping job '%s': settings are invalid once job overrides are applied (%s)", scrapeConfig.JobName, err)
+			err = nil
+			continue
 		}
 
-		*staticConfigs = append(*staticConfigs, staticConfig)
+		routedScrapeConfigs = append(routedScrapeConfigs, routeTargets(scrapeConfig, cfg.ProxyRoutes, cfg.ProxyURL, certnameLabel, jobNames)...)
 	}
+	scrapeConfigs = routedScrapeConfigs
+
+	types.SortScrapeConfigs(scrapeConfigs)
 
 	return
 }
 
-func (p *PuppetDB) getResources() (resources []*types.Resource, err error) {
-	form := strings.NewReader(fmt.Sprintf("{\"query\":\"%s\"}", p.query))
+// mergeScrapeSettings sets the settings of src which are unset in dst. It
+// returns the names of the settings set in both with different values, in
+// which case dst is left untouched.
+func mergeScrapeSettings(dst, src *types.ScrapeSettings) (conflicts []string) {
+	merged := *dst
+
+	mergedValue := reflect.ValueOf(&merged).Elem()
+	srcValue := reflect.ValueOf(src).Elem()
+
+	for i := 0; i < srcValue.NumField(); i++ {
+		srcField := srcValue.Field(i)
+		mergedField := mergedValue.Field(i)
+
+		if srcField.IsZero() {
+			continue
+		}
+
+		if mergedField.IsZero() {
+			mergedField.Set(srcField)
+			continue
+		}
+
+		if !reflect.DeepEqual(mergedField.Interface(), srcField.Interface()) {
+			name, _, _ := strings.Cut(srcValue.Type().Field(i).Tag.Get("yaml"), ",")
+			conflicts = append(conflicts, name)
+		}
+	}
+
+	if len(conflicts) == 0 {
+		*dst = merged
+	}
+	return
+}
+
+// runQuery sends a PQL query to the PuppetDB and returns the response body
+func (p *PuppetDB) runQuery(query string) (body []byte, err error) {
+	form := strings.NewReader(fmt.Sprintf("{\"query\":\"%s\"}", query))
 	puppetdbURL := fmt.Sprintf("%s/pdb/query/v4", p.url)
 	req, err := http.NewRequest("POST", puppetdbURL, form)
 	if err != nil {
@@ -155,16 +321,93 @@ func (p *PuppetDB) getResources() (resources []*types.Resource, err error) {
 		err = fmt.Errorf("HTTP request failed (%s)", err)
 		return
 	}
+	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	body, err = io.ReadAll(resp.Body)
 	if err != nil {
 		err = fmt.Errorf("failed to read HTTP response body (%s)", err)
 		return
 	}
+	return
+}
+
+func (p *PuppetDB) getResources() (resources []*types.Resource, err error) {
+	body, err := p.runQuery(p.query)
+	if err != nil {
+		return
+	}
+
+	var rawResources []json.RawMessage
+	err = json.Unmarshal(body, &rawResources)
+	if err != nil {
+		err = fmt.Errorf("failed to unmarshal HTTP response body to JSON (%s)", err)
+		return
+	}
+
+	// Resources are decoded one by one so that a malformed resource does not
+	// prevent the discovery of the other ones
+	for _, rawResource := range rawResources {
+		resource, err := decodeResource(rawResource)
+		if err != nil {
+			log.Warnf("Skipping malformed resource: %s", err)
+			continue
+		}
+
+		resources = append(resources, resource)
+	}
+	return
+}
+
+// getCatalogTimestamps returns the producer timestamp of the catalog of each
+// node
+func (p *PuppetDB) getCatalogTimestamps() (timestamps map[string]time.Time, err error) {
+	body, err := p.runQuery(catalogTimestampsQuery)
+	if err != nil {
+		return
+	}
 
-	err = json.Unmarshal(body, &resources)
+	var catalogs []struct {
+		Certname          string    `json:"certname"`
+		ProducerTimestamp time.Time `json:"producer_timestamp"`
+	}
+	err = json.Unmarshal(body, &catalogs)
 	if err != nil {
 		err = fmt.Errorf("failed to unmarshal HTTP response body to JSON (%s)", err)
+		return
+	}
+
+	timestamps = make(map[string]time.Time, len(catalogs))
+	for _, catalog := range catalogs {
+		timestamps[catalog.Certname] = catalog.ProducerTimestamp
+	}
+	return
+}
+
+// decodeResource decodes a single Puppet resource, returning an error
+// mentioning the certname of the resource if it is malformed
+func decodeResource(data []byte) (resource *types.Resource, err error) {
+	var header struct {
+		Certname interface{} `json:"certname"`
+	}
+
+	err = json.Unmarshal(data, &header)
+	if err != nil {
+		return nil, fmt.Errorf("resource is not an object")
+	}
+
+	certname, ok := header.Certname.(string)
+	if !ok || certname == "" {
+		return nil, fmt.Errorf("resource has no valid certname")
+	}
+
+	resource = &types.Resource{}
+	err = json.Unmarshal(data, resource)
+	if err != nil {
+		return nil, fmt.Errorf("certname '%s': %s", certname, err)
+	}
+
+	if resource.Parameters.JobName == "" {
+		return nil, fmt.Errorf("certname '%s': job_name is empty", certname)
 	}
 	return
 }
@@ -3,6 +3,7 @@ package puppetdb
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -11,6 +12,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	yaml "gopkg.in/yaml.v1"
 
 	"github.com/camptocamp/prometheus-puppetdb-sd/internal/config"
 	"github.com/camptocamp/prometheus-puppetdb-sd/internal/types"
@@ -271,9 +273,150 @@ func TestGetResources(t *testing.T) {
 	assert.Equal(t, expectedResult, result)
 }
 
+var fakeMalformedResponse = `
+[
+	{
+		"certname": "server-1.example.com",
+		"parameters": {
+			"job_name": "node-exporter",
+			"targets": [
+				"server-1.example.com:9100"
+			],
+			"labels": {
+				"port": 9100,
+				"ha": true,
+				"ratio": 0.5,
+				"team": "team-1",
+				"owner": null
+			}
+		}
+	},
+	{
+		"certname": "server-2.example.com",
+		"parameters": {
+			"job_name": "node-exporter",
+			"targets": "server-2.example.com:9100"
+		}
+	},
+	{
+		"certname": "server-3.example.com",
+		"parameters": {
+			"job_name": "",
+			"targets": [
+				"server-3.example.com:9100"
+			]
+		}
+	},
+	{
+		"certname": "server-4.example.com",
+		"parameters": {
+			"job_name": "node-exporter",
+			"targets": [
+				"server-4.example.com:9100"
+			],
+			"labels": {
+				"team": ["team-1", "team-2"]
+			}
+		}
+	},
+	{
+		"certname": 5,
+		"parameters": {
+			"job_name": "node-exporter"
+		}
+	},
+	"server-6.example.com"
+]
+`
+
+func TestGetResourcesMalformed(t *testing.T) {
+	expectedResult := []*types.Resource{
+		{
+			Certname: "server-1.example.com",
+			Parameters: types.Parameters{
+				JobName: "node-exporter",
+				Targets: []string{
+					"server-1.example.com:9100",
+				},
+				Labels: map[string]string{
+					"port":  "9100",
+					"ha":    "true",
+					"ratio": "0.5",
+					"team":  "team-1",
+				},
+			},
+		},
+	}
+
+	// Mock http server
+	ts := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/pdb/query/v4" {
+				w.Header().Add("Content-Type", "application/json")
+				w.Write([]byte(fakeMalformedResponse))
+			}
+		}),
+	)
+	defer ts.Close()
+
+	config := config.PuppetDBConfig{
+		URL: ts.URL,
+	}
+
+	client, err := NewClient(&config)
+	if err != nil {
+		assert.FailNow(t, "Failed to create PuppetDB client", err.Error())
+	}
+
+	result, err := client.getResources()
+	if err != nil {
+		assert.FailNow(t, "Failed to get Puppet resources", err.Error())
+	}
+
+	assert.Equal(t, expectedResult, result)
+}
+
+func TestDecodeResourceErrors(t *testing.T) {
+	for input, expectedError := range map[string]string{
+		`{"certname": "a", "parameters": {"job_name": "j", "targets": "t"}}`:                                        "certname 'a': targets is not a list of strings",
+		`{"certname": "a", "parameters": {"job_name": ""}}`:                                                         "certname 'a': job_name is empty",
+		`{"certname": "a", "parameters": {"job_name": 1}}`:                                                          "certname 'a': job_name is not a string",
+		`{"certname": "a", "parameters": {"job_name": "j", "labels": {"l": {}}}}`:                                   "certname 'a': label 'l' is not a scalar value",
+		`{"certname": "a", "parameters": []}`:                                                                       "certname 'a': parameters are not an object",
+		`{"certname": "a", "parameters": {"job_name": "j", "scrape_interval": "banana"}}`:                           "certname 'a': scrape_interval 'banana' is not a valid duration",
+		`{"certname": "a", "parameters": {"job_name": "j", "scrape_interval": "10s", "scrape_timeout": "1m"}}`:      "certname 'a': scrape_timeout '1m' is greater than scrape_interval '10s'",
+		`{"certname": "a", "parameters": {"job_name": "j", "scheme": "ftp"}}`:                                       "certname 'a': scheme 'ftp' is not http or https",
+		`{"certname": "a", "parameters": {"job_name": "j", "metrics_path": "metrics"}}`:                             "certname 'a': metrics_path 'metrics' does not start with '/'",
+		`{"certname": "a", "parameters": {"job_name": "j", "sample_limit": -1}}`:                                    "certname 'a': sample_limit -1 is negative",
+		`{"certname": "a", "parameters": {"job_name": "j", "basic_auth": {"password": "p", "password_file": "f"}}}`: "certname 'a': basic_auth has both password and password_file",
+		`{"parameters": {"job_name": "j"}}`:                                                                         "resource has no valid certname",
+		`[]`:                                                                                                        "resource is not an object",
+	} {
+		_, err := decodeResource([]byte(input))
+		if assert.Error(t, err, input) {
+			assert.Equal(t, expectedError, err.Error(), input)
+		}
+	}
+}
+
 // This test is badly written, we should use mocks instead
 func TestGetScrapeConfigs(t *testing.T) {
 	expectedResult := []*types.ScrapeConfig{
+		{
+			JobName: "apache-exporter",
+			StaticConfigs: []*types.StaticConfig{
+				{
+					Targets: []string{
+						"server-1.example.com:9117",
+					},
+					Labels: map[string]string{
+						"certname":    "server-1.example.com",
+						"environment": "production",
+						"team":        "team-2",
+					},
+				},
+			},
+		},
 		{
 			JobName: "node-exporter",
 			StaticConfigs: []*types.StaticConfig{
@@ -307,17 +450,117 @@ func TestGetScrapeConfigs(t *testing.T) {
 				},
 			},
 		},
+	}
+
+	// Mock http server
+	ts := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/pdb/query/v4" {
+				w.Header().Add("Content-Type", "application/json")
+				w.Write([]byte(fakeResponse))
+			}
+		}),
+	)
+	defer ts.Close()
+
+	cfg := config.PuppetDBConfig{
+		URL: ts.URL,
+	}
+
+	client, err := NewClient(&cfg)
+	if err != nil {
+		assert.FailNow(t, "Failed to create PuppetDB client", err.Error())
+	}
+
+	result, err := client.GetScrapeConfigs(&config.PrometheusSDConfig{})
+	if err != nil {
+		assert.FailNow(t, "Failed to get Prometheus scrape configurations", err.Error())
+	}
+
+	assert.Equal(t, expectedResult, result)
+}
+
+var fakeScrapeSettingsResponse = `
+[
+	{
+		"certname": "server-1.example.com",
+		"parameters": {
+			"job_name": "node-exporter",
+			"targets": [
+				"server-1.example.com:9100"
+			],
+			"scheme": "https",
+			"metrics_path": "/node/metrics",
+			"honor_labels": false,
+			"tls_config": {
+				"ca_file": "/etc/prometheus/ca.pem"
+			}
+		}
+	},
+	{
+		"certname": "server-2.example.com",
+		"parameters": {
+			"job_name": "node-exporter",
+			"targets": [
+				"server-2.example.com:9100"
+			],
+			"scheme": "https",
+			"sample_limit": 1000,
+			"basic_auth": {
+				"username": "prometheus",
+				"password_file": "/etc/prometheus/password"
+			}
+		}
+	},
+	{
+		"certname": "server-3.example.com",
+		"parameters": {
+			"job_name": "node-exporter",
+			"targets": [
+				"server-3.example.com:9100"
+			],
+			"scheme": "http",
+			"metrics_path": "/metrics"
+		}
+	}
+]
+`
+
+func TestGetScrapeConfigsScrapeSettings(t *testing.T) {
+	honorLabels := false
+	sampleLimit := 1000
+
+	expectedResult := []*types.ScrapeConfig{
 		{
-			JobName: "apache-exporter",
+			JobName: "node-exporter",
+			ScrapeSettings: types.ScrapeSettings{
+				HonorLabels: &honorLabels,
+				MetricsPath: "/node/metrics",
+				Scheme:      "https",
+				SampleLimit: &sampleLimit,
+				BasicAuth: &types.BasicAuth{
+					Username:     "prometheus",
+					PasswordFile: "/etc/prometheus/password",
+				},
+				TLSConfig: &types.TLSConfig{
+					CAFile: "/etc/prometheus/ca.pem",
+				},
+			},
 			StaticConfigs: []*types.StaticConfig{
 				{
 					Targets: []string{
-						"server-1.example.com:9117",
+						"server-1.example.com:9100",
 					},
 					Labels: map[string]string{
-						"certname":    "server-1.example.com",
-						"environment": "production",
-						"team":        "team-2",
+						"certname": "server-1.example.com",
+					},
+				},
+				{
+					Targets: []string{
+						"server-2.example.com:9100",
+					},
+					Labels: map[string]string{
+						"certname": "server-2.example.com",
 					},
 				},
 			},
@@ -329,7 +572,7 @@ func TestGetScrapeConfigs(t *testing.T) {
 		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			if r.URL.Path == "/pdb/query/v4" {
 				w.Header().Add("Content-Type", "application/json")
-				w.Write([]byte(fakeResponse))
+				w.Write([]byte(fakeScrapeSettingsResponse))
 			}
 		}),
 	)
@@ -351,3 +594,333 @@ func TestGetScrapeConfigs(t *testing.T) {
 
 	assert.Equal(t, expectedResult, result)
 }
+
+var fakeInvalidScrapeSettingsResponse = `
+[
+	{
+		"certname": "server-1.example.com",
+		"parameters": {
+			"job_name": "node-exporter",
+			"targets": ["server-1.example.com:9100"],
+			"scrape_interval": "10s"
+		}
+	},
+	{
+		"certname": "server-2.example.com",
+		"parameters": {
+			"job_name": "node-exporter",
+			"targets": ["server-2.example.com:9100"],
+			"scrape_timeout": "30s"
+		}
+	},
+	{
+		"certname": "server-3.example.com",
+		"parameters": {
+			"job_name": "node-exporter",
+			"targets": ["server-3.example.com:9100"],
+			"scheme": "ftp"
+		}
+	}
+]
+`
+
+func TestGetScrapeConfigsInvalidScrapeSettings(t *testing.T) {
+	ts := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/pdb/query/v4" {
+				w.Header().Add("Content-Type", "application/json")
+				w.Write([]byte(fakeInvalidScrapeSettingsResponse))
+			}
+		}),
+	)
+	defer ts.Close()
+
+	client, err := NewClient(&config.PuppetDBConfig{URL: ts.URL})
+	if err != nil {
+		assert.FailNow(t, "Failed to create PuppetDB client", err.Error())
+	}
+
+	result, err := client.GetScrapeConfigs(&config.PrometheusSDConfig{})
+	if err != nil {
+		assert.FailNow(t, "Failed to get Prometheus scrape configurations", err.Error())
+	}
+
+	// The timeout of server-2 is greater than the interval of server-1, and
+	// the scheme of server-3 is invalid
+	if assert.Len(t, result, 1) {
+		assert.Equal(t, "10s", result[0].ScrapeInterval)
+		assert.Empty(t, result[0].ScrapeTimeout)
+		if assert.Len(t, result[0].StaticConfigs, 1) {
+			assert.Equal(t, []string{"server-1.example.com:9100"}, result[0].StaticConfigs[0].Targets)
+		}
+	}
+}
+
+func TestGetScrapeConfigsInvalidJobOverrides(t *testing.T) {
+	ts := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/pdb/query/v4" {
+				w.Header().Add("Content-Type", "application/json")
+				w.Write([]byte(fakeResponse))
+			}
+		}),
+	)
+	defer ts.Close()
+
+	client, err := NewClient(&config.PuppetDBConfig{URL: ts.URL})
+	if err != nil {
+		assert.FailNow(t, "Failed to create PuppetDB client", err.Error())
+	}
+
+	result, err := client.GetScrapeConfigs(&config.PrometheusSDConfig{
+		JobOverrides: []*config.JobOverride{
+			{
+				JobName: "node-exporter",
+				Default: types.JobSettings{
+					ScrapeSettings: types.ScrapeSettings{
+						ScrapeTimeout: "10s",
+					},
+				},
+				Override: types.JobSettings{
+					ScrapeSettings: types.ScrapeSettings{
+						ScrapeInterval: "5s",
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		assert.FailNow(t, "Failed to get Prometheus scrape configurations", err.Error())
+	}
+
+	// The default timeout is greater than the overridden interval
+	if assert.Len(t, result, 1) {
+		assert.Equal(t, "apache-exporter", result[0].JobName)
+	}
+}
+
+func TestMergeScrapeSettingsConflicts(t *testing.T) {
+	dst := types.ScrapeSettings{
+		Scheme:        "https",
+		ScrapeTimeout: "10s",
+	}
+	src := types.ScrapeSettings{
+		Scheme:         "http",
+		ScrapeTimeout:  "5s",
+		ScrapeInterval: "30s",
+	}
+
+	conflicts := mergeScrapeSettings(&dst, &src)
+
+	assert.Equal(t, []string{"scrape_timeout", "scheme"}, conflicts)
+	assert.Equal(t, types.ScrapeSettings{Scheme: "https", ScrapeTimeout: "10s"}, dst)
+}
+
+// fakeConflictingResponse holds resources whose result depends on the order
+// they are processed in: a settings conflict and a duplicate target with
+// different labels
+var fakeConflictingResponse = `
+[
+	{
+		"certname": "server-2.example.com",
+		"parameters": {
+			"job_name": "apache-exporter",
+			"targets": ["server-2.example.com:9117"],
+			"scheme": "http"
+		}
+	},
+	{
+		"certname": "server-1.example.com",
+		"parameters": {
+			"job_name": "apache-exporter",
+			"targets": ["server-1.example.com:9117"],
+			"scheme": "https"
+		}
+	},
+	{
+		"certname": "server-2.example.com",
+		"parameters": {
+			"job_name": "node-exporter",
+			"targets": ["shared.example.com:9100"],
+			"labels": {"x": "2"}
+		}
+	},
+	{
+		"certname": "server-1.example.com",
+		"parameters": {
+			"job_name": "node-exporter",
+			"targets": ["shared.example.com:9100"],
+			"labels": {"x": "1"}
+		}
+	}
+]
+`
+
+func TestGetScrapeConfigsDeterministic(t *testing.T) {
+	var response []byte
+
+	// Mock http server
+	ts := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/pdb/query/v4" {
+				w.Header().Add("Content-Type", "application/json")
+				w.Write(response)
+			}
+		}),
+	)
+	defer ts.Close()
+
+	cfg := config.PuppetDBConfig{
+		URL: ts.URL,
+	}
+
+	client, err := NewClient(&cfg)
+	if err != nil {
+		assert.FailNow(t, "Failed to create PuppetDB client", err.Error())
+	}
+
+	for _, fixture := range []string{fakeResponse, fakeConflictingResponse} {
+		var rawResources []json.RawMessage
+		err := json.Unmarshal([]byte(fixture), &rawResources)
+		if err != nil {
+			assert.FailNow(t, "Failed to unmarshal fake response", err.Error())
+		}
+
+		for _, strategy := range []config.DuplicateTargetStrategy{config.FirstDuplicateTarget, config.MergeDuplicateTargets} {
+			var expectedOutput []byte
+
+			for i := range rawResources {
+				// Rotate resources, and reverse them
+				permutation := append(append([]json.RawMessage{}, rawResources[i:]...), rawResources[:i]...)
+				reversed := make([]json.RawMessage, len(permutation))
+				for j, rawResource := range permutation {
+					reversed[len(permutation)-1-j] = rawResource
+				}
+
+				for _, resources := range [][]json.RawMessage{permutation, reversed} {
+					response, err = json.Marshal(resources)
+					if err != nil {
+						assert.FailNow(t, "Failed to marshal permuted response", err.Error())
+					}
+
+					result, err := client.GetScrapeConfigs(&config.PrometheusSDConfig{DuplicateTargetStrategy: strategy})
+					if err != nil {
+						assert.FailNow(t, "Failed to get Prometheus scrape configurations", err.Error())
+					}
+
+					output, err := yaml.Marshal(result)
+					if err != nil {
+						assert.FailNow(t, "Failed to marshal scrape configurations", err.Error())
+					}
+
+					if expectedOutput == nil {
+						expectedOutput = output
+					}
+					assert.Equal(t, string(expectedOutput), string(output))
+				}
+			}
+
+			if fixture == fakeConflictingResponse {
+				// Resources are processed in certname order
+				assert.Contains(t, string(expectedOutput), "scheme: https\n")
+				assert.Contains(t, string(expectedOutput), "x: \"1\"\n")
+			}
+		}
+	}
+}
+
+func TestGetScrapeConfigsFilters(t *testing.T) {
+	// Mock http server
+	ts := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/pdb/query/v4" {
+				w.Header().Add("Content-Type", "application/json")
+				w.Write([]byte(fakeResponse))
+			}
+		}),
+	)
+	defer ts.Close()
+
+	client, err := NewClient(&config.PuppetDBConfig{URL: ts.URL})
+	if err != nil {
+		assert.FailNow(t, "Failed to create PuppetDB client", err.Error())
+	}
+
+	result, err := client.GetScrapeConfigs(&config.PrometheusSDConfig{
+		IncludeJobs:      []string{"node-exporter"},
+		ExcludeCertnames: []string{"server-3.*"},
+		ExcludeLabels:    []string{"environment=development"},
+	})
+	if err != nil {
+		assert.FailNow(t, "Failed to get Prometheus scrape configurations", err.Error())
+	}
+
+	assert.Equal(t, []*types.ScrapeConfig{
+		{
+			JobName: "node-exporter",
+			StaticConfigs: []*types.StaticConfig{
+				{
+					Targets: []string{
+						"server-1.example.com:9100",
+					},
+					Labels: map[string]string{
+						"certname":    "server-1.example.com",
+						"environment": "production",
+						"team":        "team-1",
+					},
+				},
+			},
+		},
+	}, result)
+}
+
+func TestGetScrapeConfigsExtraLabels(t *testing.T) {
+	// Mock http server
+	ts := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/pdb/query/v4" {
+				w.Header().Add("Content-Type", "application/json")
+				w.Write([]byte(fakeResponse))
+			}
+		}),
+	)
+	defer ts.Close()
+
+	client, err := NewClient(&config.PuppetDBConfig{URL: ts.URL})
+	if err != nil {
+		assert.FailNow(t, "Failed to create PuppetDB client", err.Error())
+	}
+
+	result, err := client.GetScrapeConfigs(&config.PrometheusSDConfig{
+		ExtraLabels:   map[string]string{"managed_by": "puppet", "team": "ops"},
+		CertnameLabel: "node",
+		IncludeJobs:   []string{"apache-exporter"},
+	})
+	if err != nil {
+		assert.FailNow(t, "Failed to get Prometheus scrape configurations", err.Error())
+	}
+
+	if assert.Len(t, result, 1) && assert.Len(t, result[0].StaticConfigs, 1) {
+		assert.Equal(t, map[string]string{
+			"node":        "server-1.example.com",
+			"environment": "production",
+			"team":        "team-2",
+			"managed_by":  "puppet",
+		}, result[0].StaticConfigs[0].Labels)
+	}
+
+	result, err = client.GetScrapeConfigs(&config.PrometheusSDConfig{
+		DropCertnameLabel: true,
+		IncludeJobs:       []string{"apache-exporter"},
+	})
+	if err != nil {
+		assert.FailNow(t, "Failed to get Prometheus scrape configurations", err.Error())
+	}
+
+	if assert.Len(t, result, 1) && assert.Len(t, result[0].StaticConfigs, 1) {
+		assert.Equal(t, map[string]string{
+			"environment": "production",
+			"team":        "team-2",
+		}, result[0].StaticConfigs[0].Labels)
+	}
+}
@@ -0,0 +1,88 @@
+package puppetdb
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/config"
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/types"
+)
+
+// routeTargets sets the proxy of a scrape configuration from the first
+// matching proxy route, splitting jobs with mixed routes into one job per proxy
+func routeTargets(scrapeConfig *types.ScrapeConfig, routes []*config.ProxyRoute, defaultProxyURL, certnameLabel string, jobNames map[string]struct{}) (scrapeConfigs []*types.ScrapeConfig) {
+	routeConfigs := map[string]*types.ScrapeConfig{}
+	routeNames := map[string]string{}
+	routeOrder := map[string]int{}
+
+	for _, staticConfig := range scrapeConfig.StaticConfigs {
+		name, proxyURL, order := config.DefaultProxyRoute, defaultProxyURL, len(routes)
+		for i, route := range routes {
+			if route.Matches(scrapeConfig.JobName, staticConfig.Labels[certnameLabel], staticConfig.Labels) {
+				name, proxyURL, order = route.Name, route.ProxyURL, i
+				break
+			}
+		}
+
+		if scheme, ok := staticConfig.Labels["__scheme__"]; ok && proxyURL != "" {
+			labels := make(map[string]string, len(staticConfig.Labels)+1)
+			for name, value := range staticConfig.Labels {
+				labels[name] = value
+			}
+			labels["__scheme__"] = "http"
+			labels["__param__scheme"] = scheme
+			staticConfig.Labels = labels
+		}
+
+		routeConfig, ok := routeConfigs[proxyURL]
+		if !ok {
+			c := *scrapeConfig
+			c.ProxyURL = proxyURL
+			c.StaticConfigs = nil
+			routeConfig = &c
+
+			routeConfigs[proxyURL] = routeConfig
+			scrapeConfigs = append(scrapeConfigs, routeConfig)
+		}
+		// Name the scrape configuration after the first route of the proxy
+		if o, ok := routeOrder[proxyURL]; !ok || order < o {
+			routeNames[proxyURL] = name
+			routeOrder[proxyURL] = order
+		}
+
+		routeConfig.StaticConfigs = append(routeConfig.StaticConfigs, staticConfig)
+	}
+
+	if len(scrapeConfigs) == 0 {
+		scrapeConfig.ProxyURL = defaultProxyURL
+		return []*types.ScrapeConfig{scrapeConfig}
+	}
+
+	if len(scrapeConfigs) == 1 {
+		return
+	}
+
+	for _, routeConfig := range scrapeConfigs {
+		jobName := scrapeConfig.JobName + "-" + routeNames[routeConfig.ProxyURL]
+
+		routeConfig.JobName = jobName
+		for i := 2; ; i++ {
+			if _, ok := jobNames[routeConfig.JobName]; !ok {
+				break
+			}
+			routeConfig.JobName = fmt.Sprintf("%s-%d", jobName, i)
+		}
+		if routeConfig.JobName != jobName {
+			log.Warnf("Job '%s' of route '%s' of job '%s' is named '%s', since the name is already in use", jobName, routeNames[routeConfig.ProxyURL], scrapeConfig.JobName, routeConfig.JobName)
+		}
+		jobNames[routeConfig.JobName] = struct{}{}
+
+		for _, staticConfig := range routeConfig.StaticConfigs {
+			if _, ok := staticConfig.Labels["job"]; !ok {
+				staticConfig.Labels["job"] = scrapeConfig.JobName
+			}
+		}
+	}
+	return
+}
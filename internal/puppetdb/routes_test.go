@@ -0,0 +1,151 @@
+package puppetdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/config"
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/types"
+)
+
+func TestRouteTargetsSingleRoute(t *testing.T) {
+	scrapeConfig := &types.ScrapeConfig{
+		JobName: "node-exporter",
+		StaticConfigs: []*types.StaticConfig{
+			{
+				Targets: []string{"server-1.example.com:9100"},
+				Labels:  map[string]string{"certname": "server-1.example.com", "__scheme__": "https"},
+			},
+		},
+	}
+
+	scrapeConfigs := routeTargets(scrapeConfig, nil, "http://proxy.example.com:3128", "certname", map[string]struct{}{"node-exporter": {}})
+
+	assert.Equal(t, []*types.ScrapeConfig{
+		{
+			JobName:  "node-exporter",
+			ProxyURL: "http://proxy.example.com:3128",
+			StaticConfigs: []*types.StaticConfig{
+				{
+					Targets: []string{"server-1.example.com:9100"},
+					Labels: map[string]string{
+						"certname":        "server-1.example.com",
+						"__scheme__":      "http",
+						"__param__scheme": "https",
+					},
+				},
+			},
+		},
+	}, scrapeConfigs)
+}
+
+func TestRouteTargetsMixedRoutes(t *testing.T) {
+	routes := testProxyRoutes(t)
+
+	scrapeConfig := &types.ScrapeConfig{
+		JobName: "node-exporter",
+		StaticConfigs: []*types.StaticConfig{
+			{
+				Targets: []string{"server-1.dc1.example.com:9100"},
+				Labels:  map[string]string{"certname": "server-1.dc1.example.com"},
+			},
+			{
+				Targets: []string{"server-2.example.com:9100"},
+				Labels:  map[string]string{"certname": "server-2.example.com", "network": "lan"},
+			},
+			{
+				Targets: []string{"server-3.example.com:9100"},
+				Labels:  map[string]string{"certname": "server-3.example.com"},
+			},
+		},
+	}
+
+	scrapeConfigs := routeTargets(scrapeConfig, routes, "http://proxy.example.com:3128", "certname", map[string]struct{}{"node-exporter": {}})
+
+	assert.Equal(t, []*types.ScrapeConfig{
+		{
+			JobName:  "node-exporter-dc1",
+			ProxyURL: "http://proxy.dc1.example.com:3128",
+			StaticConfigs: []*types.StaticConfig{
+				{
+					Targets: []string{"server-1.dc1.example.com:9100"},
+					Labels:  map[string]string{"certname": "server-1.dc1.example.com", "job": "node-exporter"},
+				},
+			},
+		},
+		{
+			JobName: "node-exporter-direct",
+			StaticConfigs: []*types.StaticConfig{
+				{
+					Targets: []string{"server-2.example.com:9100"},
+					Labels:  map[string]string{"certname": "server-2.example.com", "network": "lan", "job": "node-exporter"},
+				},
+			},
+		},
+		{
+			JobName:  "node-exporter-default",
+			ProxyURL: "http://proxy.example.com:3128",
+			StaticConfigs: []*types.StaticConfig{
+				{
+					Targets: []string{"server-3.example.com:9100"},
+					Labels:  map[string]string{"certname": "server-3.example.com", "job": "node-exporter"},
+				},
+			},
+		},
+	}, scrapeConfigs)
+}
+
+func TestRouteTargetsJobNameCollision(t *testing.T) {
+	routes := testProxyRoutes(t)
+
+	scrapeConfig := &types.ScrapeConfig{
+		JobName: "node-exporter",
+		StaticConfigs: []*types.StaticConfig{
+			{
+				Targets: []string{"server-1.dc1.example.com:9100"},
+				Labels:  map[string]string{"certname": "server-1.dc1.example.com"},
+			},
+			{
+				Targets: []string{"server-2.example.com:9100"},
+				Labels:  map[string]string{"certname": "server-2.example.com"},
+			},
+		},
+	}
+
+	// Another job is already named after the dc1 route
+	jobNames := map[string]struct{}{"node-exporter": {}, "node-exporter-dc1": {}, "node-exporter-dc1-2": {}}
+
+	scrapeConfigs := routeTargets(scrapeConfig, routes, "http://proxy.example.com:3128", "certname", jobNames)
+
+	jobs := []string{}
+	for _, scrapeConfig := range scrapeConfigs {
+		jobs = append(jobs, scrapeConfig.JobName)
+	}
+	assert.Equal(t, []string{"node-exporter-dc1-3", "node-exporter-default"}, jobs)
+	assert.Contains(t, jobNames, "node-exporter-dc1-3")
+	assert.Contains(t, jobNames, "node-exporter-default")
+}
+
+func testProxyRoutes(t *testing.T) []*config.ProxyRoute {
+	routes := []*config.ProxyRoute{
+		{
+			Name:          "dc1",
+			CertnameRegex: `.*\.dc1\.example\.com`,
+			ProxyURL:      "http://proxy.dc1.example.com:3128",
+		},
+		{
+			Name:   "direct",
+			Labels: map[string]string{"network": "lan"},
+		},
+	}
+
+	for _, route := range routes {
+		err := route.Compile()
+		if err != nil {
+			assert.FailNow(t, "Failed to compile proxy route", err.Error())
+		}
+	}
+
+	return routes
+}
@@ -0,0 +1,48 @@
+package puppetdb
+
+import (
+	"strconv"
+
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/relabel"
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/types"
+)
+
+// shardTargets assigns each target of a scrape configuration to a shard,
+// from the hash of its address. Static configurations are split by shard,
+// and labeled with their shard and the number of shards.
+func shardTargets(scrapeConfig *types.ScrapeConfig, shards int) {
+	if shards < 2 {
+		return
+	}
+
+	staticConfigs := []*types.StaticConfig{}
+
+	for _, staticConfig := range scrapeConfig.StaticConfigs {
+		groups := map[uint64]*types.StaticConfig{}
+
+		for _, target := range staticConfig.Targets {
+			shard := relabel.Hashmod(target, uint64(shards))
+
+			if group, ok := groups[shard]; ok {
+				group.Targets = append(group.Targets, target)
+				continue
+			}
+
+			labels := make(map[string]string, len(staticConfig.Labels)+2)
+			for name, value := range staticConfig.Labels {
+				labels[name] = value
+			}
+			labels[types.ShardLabel] = strconv.FormatUint(shard, 10)
+			labels[types.ShardsLabel] = strconv.Itoa(shards)
+
+			group := &types.StaticConfig{
+				Targets: []string{target},
+				Labels:  labels,
+			}
+			groups[shard] = group
+			staticConfigs = append(staticConfigs, group)
+		}
+	}
+
+	scrapeConfig.StaticConfigs = staticConfigs
+}
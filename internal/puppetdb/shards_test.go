@@ -0,0 +1,68 @@
+package puppetdb
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/relabel"
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/types"
+)
+
+func TestShardTargets(t *testing.T) {
+	targets := []string{
+		"server-1.example.com:9100",
+		"server-2.example.com:9100",
+		"server-3.example.com:9100",
+		"server-4.example.com:9100",
+		"server-5.example.com:9100",
+	}
+
+	scrapeConfig := &types.ScrapeConfig{
+		JobName: "node-exporter",
+		StaticConfigs: []*types.StaticConfig{
+			{
+				Targets: targets,
+				Labels:  map[string]string{"certname": "server-1.example.com"},
+			},
+		},
+	}
+
+	shardTargets(scrapeConfig, 3)
+
+	sharded := map[string]string{}
+	for _, staticConfig := range scrapeConfig.StaticConfigs {
+		assert.Equal(t, "server-1.example.com", staticConfig.Labels["certname"])
+		assert.Equal(t, "3", staticConfig.Labels[types.ShardsLabel])
+
+		for _, target := range staticConfig.Targets {
+			sharded[target] = staticConfig.Labels[types.ShardLabel]
+		}
+	}
+
+	assert.Len(t, sharded, len(targets))
+	for _, target := range targets {
+		// Shards must match what a Prometheus hashmod relabeling computes
+		assert.Equal(t, strconv.FormatUint(relabel.Hashmod(target, 3), 10), sharded[target], target)
+	}
+}
+
+func TestShardTargetsDisabled(t *testing.T) {
+	staticConfigs := []*types.StaticConfig{
+		{
+			Targets: []string{"server-1.example.com:9100"},
+			Labels:  map[string]string{"certname": "server-1.example.com"},
+		},
+	}
+
+	scrapeConfig := &types.ScrapeConfig{
+		JobName:       "node-exporter",
+		StaticConfigs: staticConfigs,
+	}
+
+	shardTargets(scrapeConfig, 1)
+
+	assert.Equal(t, staticConfigs, scrapeConfig.StaticConfigs)
+	assert.NotContains(t, scrapeConfig.StaticConfigs[0].Labels, types.ShardLabel)
+}
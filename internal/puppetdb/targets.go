@@ -0,0 +1,160 @@
+package puppetdb
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/config"
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/types"
+)
+
+var hostnameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_]([a-zA-Z0-9_.-]*[a-zA-Z0-9_])?$`)
+
+// normalizeTargets normalizes the targets of a resource. Targets are grouped
+// by the labels derived from their address, and targets which cannot be
+// parsed are returned with the reason of their rejection.
+func normalizeTargets(targets []string, defaultPort int) (groups []*types.StaticConfig, rejected map[string]error) {
+	groupMap := map[string]*types.StaticConfig{}
+
+	for _, target := range targets {
+		address, labels, err := normalizeTarget(target, defaultPort)
+		if err != nil {
+			if rejected == nil {
+				rejected = map[string]error{}
+			}
+			rejected[target] = err
+			continue
+		}
+
+		key := types.LabelsKey(labels)
+		if group, ok := groupMap[key]; ok {
+			group.Targets = append(group.Targets, address)
+			continue
+		}
+
+		group := &types.StaticConfig{
+			Targets: []string{address},
+			Labels:  labels,
+		}
+		groupMap[key] = group
+		groups = append(groups, group)
+	}
+	return
+}
+
+// normalizeTarget normalizes a target address. The scheme, path and query
+// of URL targets are moved to the __scheme__, __metrics_path__ and __param_*
+// labels, the default port is added to targets without port, and IPv6
+// addresses are bracketed. Query parameters which cannot be represented by a
+// single label are rejected.
+func normalizeTarget(target string, defaultPort int) (address string, labels map[string]string, err error) {
+	labels = map[string]string{}
+
+	address = strings.TrimSpace(target)
+	if address == "" {
+		return "", nil, fmt.Errorf("target is empty")
+	}
+	if strings.ContainsAny(address, " \t\r\n") {
+		return "", nil, fmt.Errorf("target contains whitespaces")
+	}
+
+	if strings.Contains(address, "://") {
+		var u *url.URL
+		u, err = url.Parse(address)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid URL (%s)", err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return "", nil, fmt.Errorf("unsupported scheme '%s'", u.Scheme)
+		}
+		if u.User != nil {
+			return "", nil, fmt.Errorf("credentials are not supported in target URLs")
+		}
+
+		labels["__scheme__"] = u.Scheme
+		if u.Path != "" && u.Path != "/" {
+			labels["__metrics_path__"] = u.Path
+		}
+
+		params := u.Query()
+		names := make([]string, 0, len(params))
+		for name := range params {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if !labelNameRegexp.MatchString("__param_" + name) {
+				return "", nil, fmt.Errorf("query parameter '%s' is not a valid label name", name)
+			}
+			// A label holds a single value, and Prometheus would only keep
+			// one of them
+			if len(params[name]) > 1 {
+				return "", nil, fmt.Errorf("query parameter '%s' is repeated", name)
+			}
+			labels["__param_"+name] = params[name][0]
+		}
+
+		address = u.Host
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		// Target without port, or unbracketed IPv6 address
+		host = strings.TrimSuffix(strings.TrimPrefix(address, "["), "]")
+		port = ""
+		err = nil
+	}
+
+	if net.ParseIP(host) == nil && !hostnameRegexp.MatchString(host) {
+		return "", nil, fmt.Errorf("invalid host '%s'", host)
+	}
+
+	if port == "" && defaultPort != 0 {
+		port = strconv.Itoa(defaultPort)
+	}
+
+	if port == "" {
+		if strings.Contains(host, ":") {
+			return "[" + host + "]", labels, nil
+		}
+		return host, labels, nil
+	}
+
+	portNumber, err := strconv.Atoi(port)
+	if err != nil || portNumber < 1 || portNumber > 65535 {
+		return "", nil, fmt.Errorf("invalid port '%s'", port)
+	}
+
+	return net.JoinHostPort(host, port), labels, nil
+}
+
+// reportRejectedTargets logs the targets of a resource which were rejected
+func reportRejectedTargets(jobName, certname string, rejected map[string]error) {
+	targets := make([]string, 0, len(rejected))
+	for target := range rejected {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+
+	for _, target := range targets {
+		log.Warnf("Rejecting target '%s' of job '%s' exported by certname '%s': %s", target, jobName, certname, rejected[target])
+	}
+}
+
+// defaultPort returns the default port of a job, set by the last matching
+// job override
+func defaultPort(jobName string, jobOverrides []*config.JobOverride) (port int) {
+	for _, jobOverride := range jobOverrides {
+		if jobOverride.DefaultPort != 0 && jobOverride.Matches(jobName) {
+			port = jobOverride.DefaultPort
+		}
+	}
+	return
+}
@@ -0,0 +1,254 @@
+package relabel
+
+import (
+	"crypto/md5"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/types"
+)
+
+const (
+	defaultSeparator   = ";"
+	defaultRegex       = "(.*)"
+	defaultReplacement = "$1"
+	defaultAction      = "replace"
+
+	addressLabel = "__address__"
+	jobLabel     = "job"
+)
+
+// Relabeler applies a list of Prometheus relabel configurations to targets,
+// following Prometheus semantics
+type Relabeler struct {
+	rules []*rule
+}
+
+// rule is a relabel configuration with its defaults applied
+type rule struct {
+	sourceLabels []string
+	separator    string
+	regex        *regexp.Regexp
+	modulus      uint64
+	targetLabel  string
+	replacement  string
+	action       string
+}
+
+// New validates relabel configurations and returns a Relabeler
+func New(relabelConfigs []*types.RelabelConfig) (r *Relabeler, err error) {
+	r = &Relabeler{}
+
+	for i, relabelConfig := range relabelConfigs {
+		rl := &rule{
+			sourceLabels: relabelConfig.SourceLabels,
+			separator:    defaultSeparator,
+			modulus:      relabelConfig.Modulus,
+			targetLabel:  relabelConfig.TargetLabel,
+			replacement:  defaultReplacement,
+			action:       strings.ToLower(relabelConfig.Action),
+		}
+
+		// As in Prometheus, an empty separator or replacement is kept
+		if relabelConfig.Separator != nil {
+			rl.separator = *relabelConfig.Separator
+		}
+		if relabelConfig.Replacement != nil {
+			rl.replacement = *relabelConfig.Replacement
+		}
+		if rl.action == "" {
+			rl.action = defaultAction
+		}
+
+		regex := relabelConfig.Regex
+		if regex == "" {
+			regex = defaultRegex
+		}
+		rl.regex, err = regexp.Compile("^(?:" + regex + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex of relabel config #%d (%s)", i, err)
+		}
+
+		switch rl.action {
+		case "replace", "lowercase":
+			if rl.targetLabel == "" {
+				return nil, fmt.Errorf("relabel config #%d: target_label is required for action '%s'", i, rl.action)
+			}
+		case "hashmod":
+			if rl.targetLabel == "" {
+				return nil, fmt.Errorf("relabel config #%d: target_label is required for action '%s'", i, rl.action)
+			}
+			if rl.modulus == 0 {
+				return nil, fmt.Errorf("relabel config #%d: modulus is required for action '%s'", i, rl.action)
+			}
+		case "keep", "drop", "labelmap", "labeldrop", "labelkeep":
+		default:
+			return nil, fmt.Errorf("relabel config #%d: unknown action '%s'", i, relabelConfig.Action)
+		}
+
+		r.rules = append(r.rules, rl)
+	}
+	return
+}
+
+// Process applies the relabel configurations to a label set. It returns the
+// resulting label set, or nil if the target is dropped.
+func (r *Relabeler) Process(labels map[string]string) map[string]string {
+	for _, rl := range r.rules {
+		labels = rl.process(labels)
+		if labels == nil {
+			return nil
+		}
+	}
+	return labels
+}
+
+func (rl *rule) process(labels map[string]string) map[string]string {
+	values := make([]string, 0, len(rl.sourceLabels))
+	for _, name := range rl.sourceLabels {
+		values = append(values, labels[name])
+	}
+	value := strings.Join(values, rl.separator)
+
+	switch rl.action {
+	case "drop":
+		if rl.regex.MatchString(value) {
+			return nil
+		}
+	case "keep":
+		if !rl.regex.MatchString(value) {
+			return nil
+		}
+	case "replace":
+		indexes := rl.regex.FindStringSubmatchIndex(value)
+		if indexes == nil {
+			break
+		}
+		// As in Prometheus, expanded names which are not valid label names
+		// are skipped
+		target := string(rl.regex.ExpandString(nil, rl.targetLabel, value, indexes))
+		if !model.LabelName(target).IsValid() {
+			break
+		}
+		result := string(rl.regex.ExpandString(nil, rl.replacement, value, indexes))
+		if result == "" {
+			delete(labels, target)
+			break
+		}
+		labels[target] = result
+	case "lowercase":
+		labels[rl.targetLabel] = strings.ToLower(value)
+	case "hashmod":
+		labels[rl.targetLabel] = fmt.Sprintf("%d", Hashmod(value, rl.modulus))
+	case "labelmap":
+		mapped := map[string]string{}
+		for name, v := range labels {
+			if !rl.regex.MatchString(name) {
+				continue
+			}
+			target := rl.regex.ReplaceAllString(name, rl.replacement)
+			if model.LabelName(target).IsValid() {
+				mapped[target] = v
+			}
+		}
+		for name, v := range mapped {
+			labels[name] = v
+		}
+	case "labeldrop":
+		for name := range labels {
+			if rl.regex.MatchString(name) {
+				delete(labels, name)
+			}
+		}
+	case "labelkeep":
+		for name := range labels {
+			if !rl.regex.MatchString(name) {
+				delete(labels, name)
+			}
+		}
+	}
+	return labels
+}
+
+// Hashmod returns the modulus of the hash of a value, as computed by the
+// hashmod action
+func Hashmod(value string, modulus uint64) uint64 {
+	return sum64(md5.Sum([]byte(value))) % modulus
+}
+
+// sum64 sums the md5 hash to an uint64, the same way Prometheus does
+func sum64(hash [md5.Size]byte) uint64 {
+	var s uint64
+
+	for i, b := range hash {
+		shift := uint64((md5.Size - i - 1) * 8)
+
+		s |= uint64(b) << shift
+	}
+	return s
+}
+
+// Apply relabels every target of the static configurations of a scrape
+// configuration. As in Prometheus, the target address is exposed as the
+// __address__ label and the job name as the job label, unless the static
+// configuration has a job label. Dropped targets are
+// removed, and targets of a static configuration ending up with the same
+// labels are grouped together.
+func (r *Relabeler) Apply(scrapeConfig *types.ScrapeConfig) {
+	if len(r.rules) == 0 {
+		return
+	}
+
+	staticConfigs := []*types.StaticConfig{}
+
+	for _, staticConfig := range scrapeConfig.StaticConfigs {
+		groups := map[string]*types.StaticConfig{}
+
+		for _, target := range staticConfig.Targets {
+			labels := make(map[string]string, len(staticConfig.Labels)+2)
+			for name, value := range staticConfig.Labels {
+				labels[name] = value
+			}
+			labels[addressLabel] = target
+
+			// A job label of the static configuration takes precedence over
+			// the job name
+			_, hasJob := labels[jobLabel]
+			if !hasJob {
+				labels[jobLabel] = scrapeConfig.JobName
+			}
+
+			labels = r.Process(labels)
+			if labels == nil {
+				continue
+			}
+
+			address := labels[addressLabel]
+			delete(labels, addressLabel)
+			if address == "" {
+				continue
+			}
+			if !hasJob && labels[jobLabel] == scrapeConfig.JobName {
+				delete(labels, jobLabel)
+			}
+
+			key := types.LabelsKey(labels)
+			if group, ok := groups[key]; ok {
+				group.Targets = append(group.Targets, address)
+				continue
+			}
+
+			group := &types.StaticConfig{
+				Targets: []string{address},
+				Labels:  labels,
+			}
+			groups[key] = group
+			staticConfigs = append(staticConfigs, group)
+		}
+	}
+
+	scrapeConfig.StaticConfigs = staticConfigs
+}
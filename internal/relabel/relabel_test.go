@@ -0,0 +1,308 @@
+package relabel
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/types"
+)
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+func TestProcess(t *testing.T) {
+	tests := []struct {
+		name           string
+		relabelConfigs []*types.RelabelConfig
+		input          map[string]string
+		expected       map[string]string
+	}{
+		{
+			name: "replace",
+			relabelConfigs: []*types.RelabelConfig{
+				{
+					SourceLabels: []string{"certname"},
+					Regex:        `(.+?)\.(.+)`,
+					TargetLabel:  "instance",
+				},
+			},
+			input:    map[string]string{"certname": "server-1.example.com"},
+			expected: map[string]string{"certname": "server-1.example.com", "instance": "server-1"},
+		},
+		{
+			name: "replace without match",
+			relabelConfigs: []*types.RelabelConfig{
+				{
+					SourceLabels: []string{"certname"},
+					Regex:        `(.+?)\.(.+)`,
+					TargetLabel:  "instance",
+				},
+			},
+			input:    map[string]string{"certname": "localhost"},
+			expected: map[string]string{"certname": "localhost"},
+		},
+		{
+			name: "replace with separator and replacement",
+			relabelConfigs: []*types.RelabelConfig{
+				{
+					SourceLabels: []string{"environment", "team"},
+					Separator:    stringPtr("/"),
+					Regex:        "(.*)/(.*)",
+					Replacement:  stringPtr("${2}-${1}"),
+					TargetLabel:  "owner",
+				},
+			},
+			input:    map[string]string{"environment": "production", "team": "team-1"},
+			expected: map[string]string{"environment": "production", "team": "team-1", "owner": "team-1-production"},
+		},
+		{
+			name: "replace with empty separator",
+			relabelConfigs: []*types.RelabelConfig{
+				{
+					SourceLabels: []string{"environment", "team"},
+					Separator:    stringPtr(""),
+					TargetLabel:  "owner",
+				},
+			},
+			input:    map[string]string{"environment": "production", "team": "team-1"},
+			expected: map[string]string{"environment": "production", "team": "team-1", "owner": "productionteam-1"},
+		},
+		{
+			name: "replace with empty replacement",
+			relabelConfigs: []*types.RelabelConfig{
+				{
+					SourceLabels: []string{"environment"},
+					Regex:        "production",
+					Replacement:  stringPtr(""),
+					TargetLabel:  "team",
+				},
+			},
+			input:    map[string]string{"environment": "production", "team": "team-1"},
+			expected: map[string]string{"environment": "production"},
+		},
+		{
+			name: "replace with invalid target label",
+			relabelConfigs: []*types.RelabelConfig{
+				{
+					SourceLabels: []string{"role"},
+					Regex:        "(.*)",
+					Replacement:  stringPtr("true"),
+					TargetLabel:  "$1",
+				},
+			},
+			input:    map[string]string{"role": "a-b"},
+			expected: map[string]string{"role": "a-b"},
+		},
+		{
+			name: "keep",
+			relabelConfigs: []*types.RelabelConfig{
+				{
+					SourceLabels: []string{"environment"},
+					Regex:        "production",
+					Action:       "keep",
+				},
+			},
+			input:    map[string]string{"environment": "development"},
+			expected: nil,
+		},
+		{
+			name: "drop",
+			relabelConfigs: []*types.RelabelConfig{
+				{
+					SourceLabels: []string{"environment"},
+					Regex:        "dev.*",
+					Action:       "drop",
+				},
+			},
+			input:    map[string]string{"environment": "development"},
+			expected: nil,
+		},
+		{
+			name: "labelmap",
+			relabelConfigs: []*types.RelabelConfig{
+				{
+					Regex:       "puppet_(.+)",
+					Replacement: stringPtr("$1"),
+					Action:      "labelmap",
+				},
+			},
+			input:    map[string]string{"puppet_environment": "production"},
+			expected: map[string]string{"puppet_environment": "production", "environment": "production"},
+		},
+		{
+			name: "labelmap with invalid label names",
+			relabelConfigs: []*types.RelabelConfig{
+				{
+					Regex:       "puppet_(.+)",
+					Replacement: stringPtr("$1"),
+					Action:      "labelmap",
+				},
+			},
+			input:    map[string]string{"puppet_environment": "production", "puppet_1st": "a"},
+			expected: map[string]string{"puppet_environment": "production", "puppet_1st": "a", "environment": "production"},
+		},
+		{
+			name: "labeldrop",
+			relabelConfigs: []*types.RelabelConfig{
+				{
+					Regex:  "metrics_path|scheme",
+					Action: "labeldrop",
+				},
+			},
+			input:    map[string]string{"metrics_path": "/metrics", "scheme": "https", "team": "team-1"},
+			expected: map[string]string{"team": "team-1"},
+		},
+		{
+			name: "labelkeep",
+			relabelConfigs: []*types.RelabelConfig{
+				{
+					Regex:  "team",
+					Action: "labelkeep",
+				},
+			},
+			input:    map[string]string{"environment": "production", "team": "team-1"},
+			expected: map[string]string{"team": "team-1"},
+		},
+		{
+			name: "hashmod",
+			relabelConfigs: []*types.RelabelConfig{
+				{
+					SourceLabels: []string{"__address__"},
+					Modulus:      8,
+					TargetLabel:  "shard",
+					Action:       "hashmod",
+				},
+			},
+			input:    map[string]string{"__address__": "server-1.example.com:9100"},
+			expected: map[string]string{"__address__": "server-1.example.com:9100", "shard": "5"},
+		},
+		{
+			name: "lowercase",
+			relabelConfigs: []*types.RelabelConfig{
+				{
+					SourceLabels: []string{"team"},
+					TargetLabel:  "team",
+					Action:       "lowercase",
+				},
+			},
+			input:    map[string]string{"team": "Team-1"},
+			expected: map[string]string{"team": "team-1"},
+		},
+	}
+
+	for _, test := range tests {
+		r, err := New(test.relabelConfigs)
+		if err != nil {
+			assert.FailNow(t, "Failed to setup relabeler", "%s: %s", test.name, err.Error())
+		}
+
+		assert.Equal(t, test.expected, r.Process(test.input), test.name)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	for _, relabelConfig := range []*types.RelabelConfig{
+		{Regex: "(", TargetLabel: "t"},
+		{Action: "replace"},
+		{Action: "hashmod", TargetLabel: "t"},
+		{Action: "unknown"},
+	} {
+		_, err := New([]*types.RelabelConfig{relabelConfig})
+		assert.Error(t, err)
+	}
+}
+
+func TestApply(t *testing.T) {
+	r, err := New([]*types.RelabelConfig{
+		{
+			SourceLabels: []string{"__address__"},
+			Regex:        "server-3.*",
+			Action:       "drop",
+		},
+		{
+			SourceLabels: []string{"__address__"},
+			Regex:        "([^:]+):.*",
+			TargetLabel:  "instance",
+		},
+		{
+			SourceLabels: []string{"__address__"},
+			Regex:        "(.*):9100",
+			Replacement:  stringPtr("${1}:9101"),
+			TargetLabel:  "__address__",
+		},
+	})
+	if err != nil {
+		assert.FailNow(t, "Failed to setup relabeler", err.Error())
+	}
+
+	scrapeConfig := &types.ScrapeConfig{
+		JobName: "node-exporter",
+		StaticConfigs: []*types.StaticConfig{
+			{
+				Targets: []string{
+					"server-1.example.com:9100",
+					"server-1.example.com:9200",
+					"server-3.example.com:9100",
+				},
+				Labels: map[string]string{
+					"certname": "server-1.example.com",
+				},
+			},
+		},
+	}
+
+	r.Apply(scrapeConfig)
+
+	assert.Equal(t, []*types.StaticConfig{
+		{
+			Targets: []string{
+				"server-1.example.com:9101",
+				"server-1.example.com:9200",
+			},
+			Labels: map[string]string{
+				"certname": "server-1.example.com",
+				"instance": "server-1.example.com",
+			},
+		},
+	}, scrapeConfig.StaticConfigs)
+}
+
+func TestApplyJobLabel(t *testing.T) {
+	r, err := New([]*types.RelabelConfig{
+		{
+			SourceLabels: []string{"job"},
+			TargetLabel:  "team_job",
+		},
+	})
+	if err != nil {
+		assert.FailNow(t, "Failed to setup relabeler", err.Error())
+	}
+
+	scrapeConfig := &types.ScrapeConfig{
+		JobName: "node-exporter",
+		StaticConfigs: []*types.StaticConfig{
+			{
+				Targets: []string{"server-1.example.com:9100"},
+				Labels:  map[string]string{"job": "node"},
+			},
+			{
+				Targets: []string{"server-2.example.com:9100"},
+			},
+		},
+	}
+
+	r.Apply(scrapeConfig)
+
+	assert.Equal(t, []*types.StaticConfig{
+		{
+			Targets: []string{"server-1.example.com:9100"},
+			Labels:  map[string]string{"job": "node", "team_job": "node"},
+		},
+		{
+			Targets: []string{"server-2.example.com:9100"},
+			Labels:  map[string]string{"team_job": "node-exporter"},
+		},
+	}, scrapeConfig.StaticConfigs)
+}
@@ -0,0 +1,71 @@
+package types
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// SortResources sorts resources by certname and job name, then by
+// parameters, so that the resources kept when they conflict do not depend on
+// the order of the PuppetDB response
+func SortResources(resources []*Resource) {
+	keys := make(map[*Resource]string, len(resources))
+
+	for _, resource := range resources {
+		// Maps are encoded with sorted keys
+		parameters, _ := json.Marshal(resource.Parameters)
+		keys[resource] = resource.Certname + "\xff" + resource.Parameters.JobName + "\xff" + string(parameters)
+	}
+
+	sort.SliceStable(resources, func(i, j int) bool {
+		return keys[resources[i]] < keys[resources[j]]
+	})
+}
+
+// SortScrapeConfigs sorts scrape configurations by job name, and their static
+// configurations by targets and labels, so that their rendering does not
+// depend on the order of the PuppetDB response
+func SortScrapeConfigs(scrapeConfigs []*ScrapeConfig) {
+	sort.SliceStable(scrapeConfigs, func(i, j int) bool {
+		return scrapeConfigs[i].JobName < scrapeConfigs[j].JobName
+	})
+
+	for _, scrapeConfig := range scrapeConfigs {
+		SortStaticConfigs(scrapeConfig.StaticConfigs)
+	}
+}
+
+// SortStaticConfigs sorts the targets of static configurations, then the
+// static configurations by targets and labels
+func SortStaticConfigs(staticConfigs []*StaticConfig) {
+	keys := make(map[*StaticConfig]string, len(staticConfigs))
+
+	for _, staticConfig := range staticConfigs {
+		sort.Strings(staticConfig.Targets)
+
+		keys[staticConfig] = strings.Join(staticConfig.Targets, "\xff") + "\xfe" + LabelsKey(staticConfig.Labels)
+	}
+
+	sort.SliceStable(staticConfigs, func(i, j int) bool {
+		return keys[staticConfigs[i]] < keys[staticConfigs[j]]
+	})
+}
+
+// LabelsKey returns a string uniquely identifying a label set
+func LabelsKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name)
+		b.WriteByte(0xff)
+		b.WriteString(labels[name])
+		b.WriteByte(0xff)
+	}
+	return b.String()
+}
@@ -0,0 +1,85 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSortScrapeConfigs(t *testing.T) {
+	scrapeConfigs := []*ScrapeConfig{
+		{
+			JobName: "node-exporter",
+			StaticConfigs: []*StaticConfig{
+				{
+					Targets: []string{"server-2.example.com:9100", "server-1.example.com:9100"},
+					Labels:  map[string]string{"team": "team-2"},
+				},
+				{
+					Targets: []string{"server-1.example.com:9100", "server-2.example.com:9100"},
+					Labels:  map[string]string{"team": "team-1"},
+				},
+			},
+		},
+		{
+			JobName: "apache-exporter",
+			StaticConfigs: []*StaticConfig{
+				{
+					Targets: []string{"server-2.example.com:9117"},
+					Labels:  map[string]string{},
+				},
+				{
+					Targets: []string{"server-1.example.com:9117"},
+					Labels:  map[string]string{},
+				},
+			},
+		},
+	}
+
+	SortScrapeConfigs(scrapeConfigs)
+
+	assert.Equal(t, []*ScrapeConfig{
+		{
+			JobName: "apache-exporter",
+			StaticConfigs: []*StaticConfig{
+				{
+					Targets: []string{"server-1.example.com:9117"},
+					Labels:  map[string]string{},
+				},
+				{
+					Targets: []string{"server-2.example.com:9117"},
+					Labels:  map[string]string{},
+				},
+			},
+		},
+		{
+			JobName: "node-exporter",
+			StaticConfigs: []*StaticConfig{
+				{
+					Targets: []string{"server-1.example.com:9100", "server-2.example.com:9100"},
+					Labels:  map[string]string{"team": "team-1"},
+				},
+				{
+					Targets: []string{"server-1.example.com:9100", "server-2.example.com:9100"},
+					Labels:  map[string]string{"team": "team-2"},
+				},
+			},
+		},
+	}, scrapeConfigs)
+}
+
+func TestSortResources(t *testing.T) {
+	resources := []*Resource{
+		{Certname: "server-2.example.com", Parameters: Parameters{JobName: "node-exporter"}},
+		{Certname: "server-1.example.com", Parameters: Parameters{JobName: "node-exporter", Labels: map[string]string{"x": "2"}}},
+		{Certname: "server-1.example.com", Parameters: Parameters{JobName: "node-exporter", Labels: map[string]string{"x": "1"}}},
+		{Certname: "server-1.example.com", Parameters: Parameters{JobName: "apache-exporter"}},
+	}
+
+	SortResources(resources)
+
+	assert.Equal(t, "apache-exporter", resources[0].Parameters.JobName)
+	assert.Equal(t, "1", resources[1].Parameters.Labels["x"])
+	assert.Equal(t, "2", resources[2].Parameters.Labels["x"])
+	assert.Equal(t, "server-2.example.com", resources[3].Certname)
+}
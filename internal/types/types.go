@@ -1,5 +1,21 @@
 package types
 
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/common/model"
+)
+
+const (
+	// ShardLabel is the label holding the shard of a target
+	ShardLabel = "shard"
+	// ShardsLabel is the label holding the number of shards
+	ShardsLabel = "shards"
+)
+
 // Resource represents a Puppet resource
 type Resource struct {
 	Certname   string     `json:"certname"`
@@ -8,22 +24,203 @@ type Resource struct {
 
 // Parameters represents the paramaters of a Puppet resource
 type Parameters struct {
-	JobName string            `json:"job_name"`
-	Targets []string          `json:"targets"`
-	Labels  map[string]string `json:"labels"`
+	JobName     string            `json:"job_name"`
+	Targets     []string          `json:"targets"`
+	Labels      map[string]string `json:"labels"`
+	ProbeModule string            `json:"probe_module"`
+
+	ScrapeSettings
+}
+
+// UnmarshalJSON decodes the parameters of a Puppet resource, coercing scalar
+// label values to strings
+func (p *Parameters) UnmarshalJSON(data []byte) (err error) {
+	var raw struct {
+		JobName     json.RawMessage            `json:"job_name"`
+		Targets     json.RawMessage            `json:"targets"`
+		Labels      map[string]json.RawMessage `json:"labels"`
+		ProbeModule json.RawMessage            `json:"probe_module"`
+	}
+
+	err = json.Unmarshal(data, &raw)
+	if err != nil {
+		return fmt.Errorf("parameters are not an object")
+	}
+
+	if raw.JobName != nil {
+		err = json.Unmarshal(raw.JobName, &p.JobName)
+		if err != nil {
+			return fmt.Errorf("job_name is not a string")
+		}
+	}
+
+	if raw.ProbeModule != nil {
+		err = json.Unmarshal(raw.ProbeModule, &p.ProbeModule)
+		if err != nil {
+			return fmt.Errorf("probe_module is not a string")
+		}
+	}
+
+	err = json.Unmarshal(data, &p.ScrapeSettings)
+	if err != nil {
+		return fmt.Errorf("invalid scrape settings (%s)", err)
+	}
+
+	err = p.ScrapeSettings.Validate()
+	if err != nil {
+		return
+	}
+
+	if raw.Targets != nil {
+		err = json.Unmarshal(raw.Targets, &p.Targets)
+		if err != nil {
+			return fmt.Errorf("targets is not a list of strings")
+		}
+	}
+
+	if raw.Labels != nil {
+		p.Labels = map[string]string{}
+		for name, value := range raw.Labels {
+			// Undefined Puppet values are exported as null
+			if bytes.Equal(bytes.TrimSpace(value), []byte("null")) {
+				continue
+			}
+
+			p.Labels[name], err = scalarToString(value)
+			if err != nil {
+				return fmt.Errorf("label '%s' %s", name, err)
+			}
+		}
+	}
+
+	return
+}
+
+// scalarToString converts a JSON scalar (string, number or boolean) to a string
+func scalarToString(value json.RawMessage) (s string, err error) {
+	value = bytes.TrimSpace(value)
+	if len(value) == 0 {
+		return
+	}
+
+	switch value[0] {
+	case '"':
+		err = json.Unmarshal(value, &s)
+	case '{', '[':
+		err = fmt.Errorf("is not a scalar value")
+	default:
+		// Numbers and booleans are kept as written in the JSON document
+		s = string(value)
+	}
+	return
 }
 
 // ScrapeConfig represents a Prometheus scrape_config
 // See https://prometheus.io/docs/prometheus/latest/configuration/configuration/#scrape_config
 type ScrapeConfig struct {
-	JobName       string          `yaml:"job_name"`
-	ProxyURL      string          `yaml:"proxy_url,omitempty"`
-	StaticConfigs []*StaticConfig `yaml:"static_configs"`
+	JobName              string `json:"job_name" yaml:"job_name"`
+	ScrapeSettings       `yaml:",inline"`
+	ProxyURL             string           `json:"proxy_url,omitempty" yaml:"proxy_url,omitempty"`
+	MetricRelabelConfigs []*RelabelConfig `json:"metric_relabel_configs,omitempty" yaml:"metric_relabel_configs,omitempty"`
+	StaticConfigs        []*StaticConfig  `json:"static_configs" yaml:"static_configs"`
+}
+
+// ScrapeSettings represents the settings of a Prometheus scrape_config which
+// can be set from Puppet resource parameters
+type ScrapeSettings struct {
+	HonorLabels    *bool               `json:"honor_labels,omitempty" yaml:"honor_labels,omitempty"`
+	Params         map[string][]string `json:"params,omitempty" yaml:"params,omitempty"`
+	ScrapeInterval string              `json:"scrape_interval,omitempty" yaml:"scrape_interval,omitempty"`
+	ScrapeTimeout  string              `json:"scrape_timeout,omitempty" yaml:"scrape_timeout,omitempty"`
+	MetricsPath    string              `json:"metrics_path,omitempty" yaml:"metrics_path,omitempty"`
+	Scheme         string              `json:"scheme,omitempty" yaml:"scheme,omitempty"`
+	SampleLimit    *int                `json:"sample_limit,omitempty" yaml:"sample_limit,omitempty"`
+	BasicAuth      *BasicAuth          `json:"basic_auth,omitempty" yaml:"basic_auth,omitempty"`
+	TLSConfig      *TLSConfig          `json:"tls_config,omitempty" yaml:"tls_config,omitempty"`
+}
+
+// Validate checks that the settings would be accepted by Prometheus, since a
+// single invalid job prevents it from loading the whole configuration
+func (s *ScrapeSettings) Validate() (err error) {
+	var interval, timeout model.Duration
+
+	if s.ScrapeInterval != "" {
+		interval, err = model.ParseDuration(s.ScrapeInterval)
+		if err != nil {
+			return fmt.Errorf("scrape_interval '%s' is not a valid duration", s.ScrapeInterval)
+		}
+	}
+
+	if s.ScrapeTimeout != "" {
+		timeout, err = model.ParseDuration(s.ScrapeTimeout)
+		if err != nil {
+			return fmt.Errorf("scrape_timeout '%s' is not a valid duration", s.ScrapeTimeout)
+		}
+	}
+
+	if interval != 0 && timeout > interval {
+		return fmt.Errorf("scrape_timeout '%s' is greater than scrape_interval '%s'", s.ScrapeTimeout, s.ScrapeInterval)
+	}
+
+	if s.Scheme != "" && s.Scheme != "http" && s.Scheme != "https" {
+		return fmt.Errorf("scheme '%s' is not http or https", s.Scheme)
+	}
+
+	if s.MetricsPath != "" && !strings.HasPrefix(s.MetricsPath, "/") {
+		return fmt.Errorf("metrics_path '%s' does not start with '/'", s.MetricsPath)
+	}
+
+	if s.SampleLimit != nil && *s.SampleLimit < 0 {
+		return fmt.Errorf("sample_limit %d is negative", *s.SampleLimit)
+	}
+
+	if s.BasicAuth != nil && s.BasicAuth.Password != "" && s.BasicAuth.PasswordFile != "" {
+		return fmt.Errorf("basic_auth has both password and password_file")
+	}
+	return
+}
+
+// BasicAuth represents the basic_auth section of a Prometheus scrape_config
+type BasicAuth struct {
+	Username     string `json:"username" yaml:"username"`
+	Password     string `json:"password,omitempty" yaml:"password,omitempty"`
+	PasswordFile string `json:"password_file,omitempty" yaml:"password_file,omitempty"`
+}
+
+// TLSConfig represents a Prometheus tls_config
+// See https://prometheus.io/docs/prometheus/latest/configuration/configuration/#tls_config
+type TLSConfig struct {
+	CAFile             string `json:"ca_file,omitempty" yaml:"ca_file,omitempty"`
+	CertFile           string `json:"cert_file,omitempty" yaml:"cert_file,omitempty"`
+	KeyFile            string `json:"key_file,omitempty" yaml:"key_file,omitempty"`
+	ServerName         string `json:"server_name,omitempty" yaml:"server_name,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty" yaml:"insecure_skip_verify,omitempty"`
+}
+
+// JobSettings represents the settings of a Prometheus scrape_config which
+// can be enforced from the service discovery configuration
+type JobSettings struct {
+	ScrapeSettings       `yaml:",inline"`
+	MetricRelabelConfigs []*RelabelConfig `yaml:"metric_relabel_configs,omitempty"`
+}
+
+// RelabelConfig represents a Prometheus relabel_config. Separator and
+// Replacement are pointers, since setting them to an empty string differs
+// from leaving them unset.
+// See https://prometheus.io/docs/prometheus/latest/configuration/configuration/#relabel_config
+type RelabelConfig struct {
+	SourceLabels []string `json:"source_labels,omitempty" yaml:"source_labels,omitempty"`
+	Separator    *string  `json:"separator,omitempty" yaml:"separator,omitempty"`
+	Regex        string   `json:"regex,omitempty" yaml:"regex,omitempty"`
+	Modulus      uint64   `json:"modulus,omitempty" yaml:"modulus,omitempty"`
+	TargetLabel  string   `json:"target_label,omitempty" yaml:"target_label,omitempty"`
+	Replacement  *string  `json:"replacement,omitempty" yaml:"replacement,omitempty"`
+	Action       string   `json:"action,omitempty" yaml:"action,omitempty"`
 }
 
 // StaticConfig represents a Prometheus static_config
 // See https://prometheus.io/docs/prometheus/latest/configuration/configuration/#static_config
 type StaticConfig struct {
-	Targets []string          `yaml:"targets"`
-	Labels  map[string]string `yaml:"labels"`
+	Targets []string          `json:"targets" yaml:"targets"`
+	Labels  map[string]string `json:"labels,omitempty" yaml:"labels"`
 }
@@ -7,8 +7,10 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/camptocamp/prometheus-puppetdb-sd/internal/config"
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/metrics"
 	"github.com/camptocamp/prometheus-puppetdb-sd/internal/outputs"
 	"github.com/camptocamp/prometheus-puppetdb-sd/internal/puppetdb"
+	"github.com/camptocamp/prometheus-puppetdb-sd/internal/types"
 )
 
 var version = "undefined"
@@ -19,6 +21,13 @@ func main() {
 
 	cfg := config.LoadConfig(version)
 
+	if cfg.MetricsListenAddress != "" {
+		go func() {
+			err := metrics.Serve(cfg.MetricsListenAddress)
+			log.Fatalf("Failed to serve metrics: %s", err)
+		}()
+	}
+
 	o, err := outputs.Setup(&cfg.Output)
 	if err != nil {
 		log.Fatalf("Failed to setup output: %s", err)
@@ -31,11 +40,19 @@ func main() {
 		return
 	}
 
+	var changes <-chan struct{}
+	if n, ok := o.(outputs.Notifier); ok {
+		changes = n.Changes()
+	}
+
+	var lastScrapeConfigs []*types.ScrapeConfig
+
 	for {
 		scrapeConfigs, err := puppetDBClient.GetScrapeConfigs(&cfg.PrometheusSD)
 		if err != nil {
 			log.Errorf("Failed to generate scrape_configs: %s", err)
 		} else {
+			lastScrapeConfigs = scrapeConfigs
 			err = o.WriteOutput(ctx, scrapeConfigs)
 			if err != nil {
 				log.Errorf("Failed to write output: %s", err)
@@ -43,6 +60,23 @@ func main() {
 		}
 
 		log.Infof("Sleeping for %v", cfg.Sleep)
-		time.Sleep(cfg.Sleep)
+		timer := time.NewTimer(cfg.Sleep)
+
+	wait:
+		for {
+			select {
+			case <-timer.C:
+				break wait
+			case <-changes:
+				if lastScrapeConfigs == nil {
+					continue
+				}
+				log.Infof("Output configuration changed, writing output again")
+				err = o.WriteOutput(ctx, lastScrapeConfigs)
+				if err != nil {
+					log.Errorf("Failed to write output: %s", err)
+				}
+			}
+		}
 	}
 }